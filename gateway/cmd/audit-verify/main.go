@@ -0,0 +1,48 @@
+// Command audit-verify walks the hash chain of an audit log and reports every
+// place where entries were edited, removed or inserted. Run it with
+//
+//	go run ./cmd/audit-verify [path]
+//
+// The path defaults to AUDIT_LOG_PATH or data/audit.log, and the entries are
+// checked under the key in AUDIT_LOG_KEY. It exits with status 1 when the chain
+// is broken. The last entry it prints should be at least the last one the
+// gateway logged; a lower one means entries were cut off the end.
+package main
+
+import (
+	"fmt"
+	"gateway/infrastructure/audit"
+	"os"
+)
+
+func main() {
+	path := os.Getenv("AUDIT_LOG_PATH")
+	if path == "" {
+		path = "data/audit.log"
+	}
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer file.Close()
+	report, err := audit.Verify(file, []byte(os.Getenv("AUDIT_LOG_KEY")))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read", path+":", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("%s: %d entries, last entry %d with hash %s\n", path, report.Entries, report.Seq, report.Hash)
+	if len(report.Breaks) == 0 {
+		fmt.Println("Chain intact")
+		return
+	}
+	for _, b := range report.Breaks {
+		fmt.Println("Chain broken at", b)
+	}
+	os.Exit(1)
+}
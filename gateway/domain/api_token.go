@@ -0,0 +1,9 @@
+package domain
+
+// ApiTokenScopeStore keeps the scopes chosen when a user created their API
+// token. Every user has at most one API token, so scopes are keyed by user id.
+type ApiTokenScopeStore interface {
+	Get(userId string) (scopes []string, found bool, err error)
+	Save(userId string, scopes []string) error
+	Delete(userId string) error
+}
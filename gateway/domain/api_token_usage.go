@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+// ApiTokenQuota limits the calls made with an API token. Zero means unlimited.
+type ApiTokenQuota struct {
+	PerMinute int64
+	PerDay    int64
+}
+
+// ApiTokenUsage counts the calls made with a user's API token in the current
+// minute and UTC day.
+type ApiTokenUsage struct {
+	MinuteStart time.Time `json:"minuteStart"`
+	Minute      int64     `json:"minute"`
+	DayStart    time.Time `json:"dayStart"`
+	Day         int64     `json:"day"`
+	Total       int64     `json:"total"`
+	LastUsed    time.Time `json:"lastUsed"`
+}
+
+// Take counts a call made at the given time unless it would exceed the quota,
+// and reports whether the call was counted.
+func (usage *ApiTokenUsage) Take(at time.Time, quota ApiTokenQuota) bool {
+	usage.Roll(at)
+	if quota.PerMinute > 0 && usage.Minute >= quota.PerMinute {
+		return false
+	}
+	if quota.PerDay > 0 && usage.Day >= quota.PerDay {
+		return false
+	}
+	usage.Minute++
+	usage.Day++
+	usage.Total++
+	usage.LastUsed = at
+	return true
+}
+
+// Roll resets the counters of windows that ended before the given time.
+func (usage *ApiTokenUsage) Roll(at time.Time) {
+	minute := at.Truncate(time.Minute)
+	if !usage.MinuteStart.Equal(minute) {
+		usage.MinuteStart = minute
+		usage.Minute = 0
+	}
+	day := at.UTC().Truncate(24 * time.Hour)
+	if !usage.DayStart.Equal(day) {
+		usage.DayStart = day
+		usage.Day = 0
+	}
+}
+
+// ApiTokenUsageStore keeps the usage of API tokens per user id.
+type ApiTokenUsageStore interface {
+	// Take counts a call made at the given time unless the quota is exhausted
+	// and returns the usage after the call.
+	Take(userId string, at time.Time, quota ApiTokenQuota) (ApiTokenUsage, bool, error)
+	Get(userId string) (ApiTokenUsage, bool, error)
+	GetAll() (map[string]ApiTokenUsage, error)
+}
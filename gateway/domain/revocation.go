@@ -0,0 +1,19 @@
+package domain
+
+import "time"
+
+// RevocationStore is the denylist of JWTs that were logged out before they
+// expired. Entries are only needed until the tokens they deny expire, so every
+// entry carries the time after which the store may forget it.
+type RevocationStore interface {
+	// RevokeToken denies the token identified by tokenId until expiresAt.
+	RevokeToken(tokenId string, expiresAt time.Time) error
+	IsTokenRevoked(tokenId string) (bool, error)
+	// RevokeUser denies every token of userId issued up to revokedAt. The entry
+	// is kept until expiresAt, when all those tokens have expired. revokedAt is
+	// kept to the second, the resolution of the iat claim, so tokens issued in
+	// the same second are denied too.
+	RevokeUser(userId string, revokedAt time.Time, expiresAt time.Time) error
+	// UserRevokedAt returns when all tokens of userId were last revoked.
+	UserRevokedAt(userId string) (time.Time, bool, error)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/audit"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// AuditInterceptor records the calls of methods with an audit rule in the audit
+// log, whether they succeed or not.
+type AuditInterceptor struct {
+	policy *config.Policy
+	log    *audit.Log
+}
+
+func NewAuditInterceptor(policy *config.Policy, log *audit.Log) *AuditInterceptor {
+	return &AuditInterceptor{
+		policy: policy,
+		log:    log,
+	}
+}
+
+// Unary records the call after the handler returns. It runs outside the auth
+// interceptor, so calls rejected there are recorded too. Failing to record an
+// operation does not fail the call, which has already taken effect.
+func (i *AuditInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, ok := i.policy.Method(info.FullMethod)
+		if !ok || method.Audit == nil {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+
+		entry := audit.Entry{
+			Action:   method.Audit.Action,
+			Method:   info.FullMethod,
+			Target:   auditTarget(method.Audit, req),
+			Outcome:  audit.OutcomeSuccess,
+			Code:     status.Code(err).String(),
+			ClientIp: clientIp(ctx),
+		}
+		if status.Code(err) != codes.OK {
+			entry.Outcome = audit.OutcomeFailure
+		}
+		if c, ok := callFromContext(ctx); ok {
+			entry.RequestId = c.get("request_id")
+			if principal := c.caller(); principal != nil && !principal.IsAnonymous() {
+				entry.ActorId = principal.UserId
+				entry.ActorRole = principal.Role
+			}
+		}
+		recordErr := i.log.Record(entry)
+		if recordErr != nil {
+			LogFromContext(ctx).Error("Failed to record " + entry.Action + " in the audit log: " + recordErr.Error())
+		}
+		return resp, err
+	}
+}
+
+func auditTarget(rule *config.AuditPolicy, req interface{}) map[string]string {
+	msg, ok := req.(proto.Message)
+	if !ok || len(rule.Target) == 0 {
+		return nil
+	}
+	target := map[string]string{}
+	for _, path := range rule.Target {
+		if value, ok := stringField(msg.ProtoReflect(), path); ok && value != "" {
+			target[path] = value
+		}
+	}
+	return target
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"gateway/infrastructure/audit"
+	"gateway/startup/config"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditInterceptorRecordsTheCaller(t *testing.T) {
+	descriptor := ownedDescriptor(t)
+	tests := []struct {
+		name    string
+		token   string
+		userId  string
+		code    codes.Code
+		actorId string
+		outcome string
+	}{
+		{"allowed call", signJwt(t, "1", "USER", false), "1", codes.OK, "1", audit.OutcomeSuccess},
+		{"call of another owner", signJwt(t, "2", "USER", false), "1", codes.PermissionDenied, "2", audit.OutcomeFailure},
+		{"call without the permission", signJwt(t, "3", "GUEST", false), "1", codes.PermissionDenied, "3", audit.OutcomeFailure},
+		{"call pending two-factor verification", signJwt(t, "4", "USER", true), "1", codes.Unauthenticated, "4", audit.OutcomeFailure},
+		{"call without credentials", "", "1", codes.Unauthenticated, "", audit.OutcomeFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			policy := testPolicy()
+			policy.Roles["GUEST"] = nil
+			policy.Methods["/test.Service/Owned"].Audit = &config.AuditPolicy{Action: "test.owned", Target: []string{"userId"}}
+			path := filepath.Join(t.TempDir(), "audit.log")
+			auditLog, err := audit.Open(path, []byte("audit key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain := grpc_middleware.ChainUnaryServer(
+				UnaryAccessLog(),
+				NewAuditInterceptor(policy, auditLog).Unary(),
+				NewAuthInterceptor(policy, testResolver(t, policy)).Unary(),
+			)
+			ctx := withCredential("", "")
+			if test.token != "" {
+				ctx = withCredential("authorization", "Bearer "+test.token)
+			}
+			_, err = chain(ctx, ownedRequest(descriptor, test.userId, "", ""), &grpc.UnaryServerInfo{FullMethod: "/test.Service/Owned"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got %v, want %v", err, test.code)
+			}
+			if err := auditLog.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entry audit.Entry
+			if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.ActorId != test.actorId || entry.Outcome != test.outcome || entry.Code != test.code.String() || entry.Target["userId"] != test.userId || entry.RequestId == "" {
+				t.Errorf("recorded %+v", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"gateway/domain"
+	"gateway/infrastructure/authcache"
+	"gateway/startup/config"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/http"
+	"time"
+)
+
+// AuthServiceServer is the gRPC service the gateway serves itself rather than
+// forwarding to a backend.
+type AuthServiceServer interface {
+	// Logout denies the caller's token until it expires.
+	Logout(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// LogoutEverywhere denies every token issued to the caller so far.
+	LogoutEverywhere(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// LogoutUser denies every token issued so far to the user whose id is the
+	// value of the request.
+	LogoutUser(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+}
+
+type AuthGatewayStruct struct {
+	config      *config.Config
+	revocations domain.RevocationStore
+	authCache   *authcache.Cache
+}
+
+func NewAuthGateway(c *config.Config, revocations domain.RevocationStore, authCache *authcache.Cache) *AuthGatewayStruct {
+	return &AuthGatewayStruct{
+		config:      c,
+		revocations: revocations,
+		authCache:   authCache,
+	}
+}
+
+func (s *AuthGatewayStruct) Logout(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error) {
+	principal := PrincipalFromContext(ctx)
+	if principal.TokenType != TokenTypeJwt {
+		return nil, status.Error(codes.InvalidArgument, "only JWT sessions can be logged out")
+	}
+	LogFromContext(ctx).WithField("userId", principal.UserId).Info("Logging out token of user")
+	err := s.revocations.RevokeToken(principal.TokenId, principal.ExpiresAt)
+	if err != nil {
+		LogFromContext(ctx).Error("Failed to revoke token: " + err.Error())
+		return nil, status.Error(codes.Internal, "failed to log out")
+	}
+	s.authCache.InvalidateCredential(authcache.KindJwt, principal.Token)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AuthGatewayStruct) LogoutEverywhere(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error) {
+	principal := PrincipalFromContext(ctx)
+	if principal.TokenType != TokenTypeJwt {
+		return nil, status.Error(codes.InvalidArgument, "only JWT sessions can be logged out")
+	}
+	return s.logoutUser(ctx, principal.UserId)
+}
+
+func (s *AuthGatewayStruct) LogoutUser(ctx context.Context, in *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	if in.GetValue() == "" {
+		return nil, invalidArgument("user id is required", fieldViolation{Field: "userId", Description: "must not be empty"})
+	}
+	return s.logoutUser(ctx, in.GetValue())
+}
+
+// logoutUser denies the tokens of userId issued until now. The entry is kept
+// for the configured JWT lifetime, after which all of them have expired.
+func (s *AuthGatewayStruct) logoutUser(ctx context.Context, userId string) (*emptypb.Empty, error) {
+	LogFromContext(ctx).WithField("userId", userId).Info("Logging out user everywhere")
+	now := time.Now()
+	err := s.revocations.RevokeUser(userId, now, now.Add(s.config.JwtLifetime))
+	if err != nil {
+		LogFromContext(ctx).Error("Failed to revoke tokens: " + err.Error())
+		return nil, status.Error(codes.Internal, "failed to log out")
+	}
+	s.authCache.InvalidateUser(userId)
+	return &emptypb.Empty{}, nil
+}
+
+const authServiceName = "gateway.AuthService"
+
+var authServiceDesc = grpc.ServiceDesc{
+	ServiceName: authServiceName,
+	HandlerType: (*AuthServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(authServiceName, "Logout", newEmpty, func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(AuthServiceServer).Logout(ctx, req.(*emptypb.Empty))
+		}),
+		unaryMethod(authServiceName, "LogoutEverywhere", newEmpty, func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(AuthServiceServer).LogoutEverywhere(ctx, req.(*emptypb.Empty))
+		}),
+		unaryMethod(authServiceName, "LogoutUser", newStringValue, func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(AuthServiceServer).LogoutUser(ctx, req.(*wrapperspb.StringValue))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func RegisterAuthServiceServer(s *grpc.Server, srv AuthServiceServer) {
+	s.RegisterService(&authServiceDesc, srv)
+}
+
+// RegisterAuthServiceHandler exposes the auth service over HTTP:
+//
+//	POST /auth/logout                 Logout
+//	POST /auth/logout/everywhere      LogoutEverywhere
+//	POST /auth/users/{userId}/logout  LogoutUser
+func RegisterAuthServiceHandler(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return registerRoutes(mux, conn, authServiceName, []route{
+		{http.MethodPost, "/auth/logout", "Logout", emptyFromPath, newEmpty},
+		{http.MethodPost, "/auth/logout/everywhere", "LogoutEverywhere", emptyFromPath, newEmpty},
+		{http.MethodPost, "/auth/users/{userId}/logout", "LogoutUser", userIdFromPath, newEmpty},
+	})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/metrics"
+	"gateway/startup/config"
+	otgo "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+type AuthInterceptor struct {
+	policy   *config.Policy
+	resolver *PrincipalResolver
+}
+
+func NewAuthInterceptor(policy *config.Policy, resolver *PrincipalResolver) *AuthInterceptor {
+	return &AuthInterceptor{
+		policy:   policy,
+		resolver: resolver,
+	}
+}
+
+// Unary enforces the policy rule of the called method and stores the caller's
+// Principal in the context passed to the handler. Public methods called with an
+// invalid token, with an API token lacking the method's scopes or with a token
+// still waiting for two-factor verification, are served to AnonymousPrincipal.
+// Methods without a rule are denied. The decision is traced in an auth span
+// tagged with the caller and the permissions checked.
+func (i *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		span, spanCtx := startSpan(ctx, "auth")
+		method, ok := i.policy.Method(info.FullMethod)
+		if !ok || method.Deny {
+			LogFromContext(ctx).Warn("Denying call to " + info.FullMethod + " which is not allowed by the policy")
+			deny(span, "denied", ErrPermissionDenied)
+			return nil, ErrPermissionDenied
+		}
+		if len(method.Permissions) > 0 {
+			span.SetTag(tagPermission, strings.Join(method.Permissions, ","))
+		}
+		if len(method.Scopes) > 0 {
+			span.SetTag(tagScopes, strings.Join(method.Scopes, ","))
+		}
+
+		principal, err := i.resolver.Resolve(spanCtx)
+		if method.Public {
+			if err != nil {
+				LogFromContext(ctx).Warn("Serving " + info.FullMethod + " anonymously, the token was rejected")
+				principal = AnonymousPrincipal
+			}
+			if principal.IsApiToken() && (len(method.Scopes) == 0 || !principal.HasScopes(method.Scopes)) {
+				principal = AnonymousPrincipal
+			}
+			if principal.TfaPending && !method.AllowTfaPending {
+				principal = AnonymousPrincipal
+			}
+			allow(ctx, span, principal)
+			return handler(contextWithPrincipal(ctx, principal), req)
+		}
+		reason := "error"
+		if err == nil && principal.IsAnonymous() {
+			reason = "missing_credentials"
+			err = ErrUnauthenticated
+		} else if status.Code(err) == codes.Unauthenticated {
+			reason = "invalid_credentials"
+		}
+		if err != nil {
+			LogFromContext(ctx).Warn("User is not authenticated")
+			deny(span, reason, err)
+			return nil, err
+		}
+		tagPrincipal(ctx, span, principal)
+		// Calls denied from here on are logged and audited with their caller.
+		recordPrincipal(ctx, principal)
+		if principal.TfaPending && !method.AllowTfaPending {
+			LogFromContext(ctx).WithField("userId", principal.UserId).Warn("User has not completed two-factor verification")
+			deny(span, "tfa_required", ErrTfaRequired)
+			return nil, ErrTfaRequired
+		}
+		err = i.authorize(principal, method)
+		if err != nil {
+			LogFromContext(ctx).Warn("User doesn't have permission to call " + info.FullMethod)
+			deny(span, "permission_denied", err)
+			return nil, err
+		}
+		err = i.checkOwnership(principal, method, req)
+		if err != nil {
+			LogFromContext(ctx).WithField("userId", principal.UserId).Warn("User is not the owner of the resource in " + info.FullMethod)
+			deny(span, "not_owner", err)
+			return nil, err
+		}
+
+		allow(ctx, span, principal)
+		return handler(contextWithPrincipal(ctx, principal), req)
+	}
+}
+
+// deny counts the denied call by reason and finishes its auth span. Calls that
+// failed for other reasons than their credentials are not counted.
+func deny(span otgo.Span, reason string, err error) {
+	if reason != "error" {
+		metrics.AuthFailures.WithLabelValues(reason).Inc()
+	}
+	span.SetTag(tagDecision, "deny")
+	span.SetTag(tagReason, reason)
+	finishSpan(span, err)
+}
+
+func allow(ctx context.Context, span otgo.Span, principal *Principal) {
+	tagPrincipal(ctx, span, principal)
+	if principal.IsAnonymous() {
+		span.SetTag(tagDecision, "anonymous")
+	} else {
+		span.SetTag(tagDecision, "allow")
+	}
+	span.Finish()
+}
+
+// tagPrincipal tags the auth span and the span of the call with the caller.
+func tagPrincipal(ctx context.Context, span otgo.Span, principal *Principal) {
+	if principal.IsAnonymous() {
+		return
+	}
+	for _, s := range []otgo.Span{span, otgo.SpanFromContext(ctx)} {
+		if s == nil {
+			continue
+		}
+		s.SetTag(tagUserId, principal.UserId)
+		s.SetTag(tagTokenType, principal.TokenType)
+		if principal.Role != "" {
+			s.SetTag(tagRole, principal.Role)
+		}
+	}
+}
+
+func (i *AuthInterceptor) authorize(principal *Principal, method *config.MethodPolicy) error {
+	// API tokens may only call methods that ask for scopes, so that a method
+	// is never opened to them by leaving its scopes out.
+	if principal.IsApiToken() {
+		if len(method.Scopes) == 0 || !principal.HasScopes(method.Scopes) {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	if method.ApiTokenOnly {
+		return ErrPermissionDenied
+	}
+	if len(method.Roles) > 0 && !contains(method.Roles, principal.Role) {
+		return ErrPermissionDenied
+	}
+	for _, permission := range method.Permissions {
+		if !i.policy.RoleHasPermission(principal.Role, permission) {
+			return ErrPermissionDenied
+		}
+	}
+
+	return nil
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
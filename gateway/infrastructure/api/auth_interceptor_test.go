@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/authcache"
+	"gateway/infrastructure/persistence"
+	"gateway/infrastructure/verifier"
+	"gateway/startup/config"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testJwtSecret = "test secret"
+
+// testPolicy grants USER reading and ADMIN reading and writing.
+func testPolicy() *config.Policy {
+	return &config.Policy{
+		Roles: map[string][]string{
+			"USER":  {"post_read"},
+			"ADMIN": {"post_read", "post_write"},
+		},
+		OwnerOverrideRoles: []string{"ADMIN"},
+		ApiTokenScopes:     []string{"post:read", "post:write"},
+		Methods: map[string]*config.MethodPolicy{
+			"/test.Service/Public": {Public: true},
+			"/test.Service/Read":   {Permissions: []string{"post_read"}},
+			"/test.Service/Write":  {Permissions: []string{"post_write"}},
+			"/test.Service/Admin":  {Roles: []string{"ADMIN"}},
+			"/test.Service/Denied": {Deny: true},
+			"/test.Service/Scoped": {Permissions: []string{"post_read"}, Scopes: []string{"post:read"}},
+			"/test.Service/Owned":  {Permissions: []string{"post_read"}, Owner: []string{"connection.userId", "userId"}},
+			"/test.Service/Feed":   {Scopes: []string{"post:read"}, ApiTokenOnly: true},
+		},
+	}
+}
+
+// fakeUserClient knows the users of the API tokens in apiTokens. Calling any
+// other method of the user service panics.
+type fakeUserClient struct {
+	userService.UserServiceClient
+	apiTokens map[string]string
+}
+
+func (c *fakeUserClient) IsApiTokenValid(ctx context.Context, in *userService.AuthRequest, opts ...grpc.CallOption) (*userService.UserIdRequest, error) {
+	userId, ok := c.apiTokens[in.Token]
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid API token")
+	}
+	return &userService.UserIdRequest{UserId: userId}, nil
+}
+
+// testResolver verifies JWTs signed by signJwt locally and knows the API
+// tokens "reader", whose user 1 was granted post:read, and "unscoped", whose
+// user 2 has the default scopes, which are none.
+func testResolver(t *testing.T, policy *config.Policy) *PrincipalResolver {
+	dir := t.TempDir()
+	keySet := filepath.Join(dir, "keys.json")
+	err := os.WriteFile(keySet, []byte(`{"keys": [{"kid": "test", "alg": "HS256", "secret": "`+testJwtSecret+`"}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.NewConfig()
+	c.JwtRevocationCheck = false
+	jwtVerifier, err := verifier.NewVerifier(keySet, verifier.ClaimNames{UserId: c.JwtUserIdClaim, Role: c.JwtRoleClaim, TfaPending: c.JwtTfaPendingClaim})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopeStore, err := persistence.NewApiTokenScopeFileStore(filepath.Join(dir, "scopes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scopeStore.Save("1", []string{"post:read"}); err != nil {
+		t.Fatal(err)
+	}
+	return &PrincipalResolver{
+		config:      c,
+		policy:      policy,
+		verifier:    jwtVerifier,
+		authCache:   authcache.NewCache(100, time.Minute),
+		scopeStore:  scopeStore,
+		revocations: persistence.NewRevocationMemoryStore(),
+		userClient:  &fakeUserClient{apiTokens: map[string]string{"reader": "1", "unscoped": "2"}},
+	}
+}
+
+// signJwt returns a JWT of userId with role that expires in an hour.
+func signJwt(t *testing.T, userId, role string, tfaPending bool) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userId":     userId,
+		"role":       role,
+		"tfaPending": tfaPending,
+		"iat":        time.Now().Unix(),
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString([]byte(testJwtSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// withCredential returns a context of an incoming call sending header.
+func withCredential(header, value string) context.Context {
+	if header == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(header, value))
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	user := signJwt(t, "1", "USER", false)
+	admin := signJwt(t, "9", "ADMIN", false)
+	tfaPending := signJwt(t, "1", "USER", true)
+	forged := user[:len(user)-4] + "AAAA"
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		code   codes.Code
+		userId string
+	}{
+		{"public method without credentials", "/test.Service/Public", "", "", codes.OK, ""},
+		{"public method with an invalid token", "/test.Service/Public", "authorization", "Bearer " + forged, codes.OK, ""},
+		{"public method with a token", "/test.Service/Public", "authorization", "Bearer " + user, codes.OK, "1"},
+		{"public method with a pending token", "/test.Service/Public", "authorization", "Bearer " + tfaPending, codes.OK, ""},
+		{"public method with an API token without its scopes", "/test.Service/Public", ApiKeyHeader, "reader", codes.OK, ""},
+		{"method missing from the policy", "/test.Service/Unknown", "authorization", "Bearer " + admin, codes.PermissionDenied, ""},
+		{"denied method", "/test.Service/Denied", "authorization", "Bearer " + admin, codes.PermissionDenied, ""},
+		{"missing credential", "/test.Service/Read", "", "", codes.Unauthenticated, ""},
+		{"invalid credential", "/test.Service/Read", "authorization", "Bearer " + forged, codes.Unauthenticated, ""},
+		{"unknown API token", "/test.Service/Read", ApiKeyHeader, "unknown", codes.Unauthenticated, ""},
+		{"pending two-factor verification", "/test.Service/Read", "authorization", "Bearer " + tfaPending, codes.Unauthenticated, ""},
+		{"granted permission", "/test.Service/Read", "authorization", "Bearer " + user, codes.OK, "1"},
+		{"missing permission", "/test.Service/Write", "authorization", "Bearer " + user, codes.PermissionDenied, ""},
+		{"permission of another role", "/test.Service/Write", "authorization", "Bearer " + admin, codes.OK, "9"},
+		{"missing role", "/test.Service/Admin", "authorization", "Bearer " + user, codes.PermissionDenied, ""},
+		{"granted role", "/test.Service/Admin", "authorization", "Bearer " + admin, codes.OK, "9"},
+		{"API token with the scopes", "/test.Service/Scoped", ApiKeyHeader, "reader", codes.OK, "1"},
+		{"API token sent as authorization", "/test.Service/Scoped", "authorization", "ApiKey reader", codes.OK, "1"},
+		{"API token with missing scopes", "/test.Service/Scoped", ApiKeyHeader, "unscoped", codes.PermissionDenied, ""},
+		{"API token on a method without scopes", "/test.Service/Read", ApiKeyHeader, "reader", codes.PermissionDenied, ""},
+		{"API token on an API token only method", "/test.Service/Feed", ApiKeyHeader, "reader", codes.OK, "1"},
+		{"JWT on an API token only method", "/test.Service/Feed", "authorization", "Bearer " + admin, codes.PermissionDenied, ""},
+	}
+	policy := testPolicy()
+	interceptor := NewAuthInterceptor(policy, testResolver(t, policy))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var principal *Principal
+			_, err := interceptor.Unary()(withCredential(test.header, test.value), nil, &grpc.UnaryServerInfo{FullMethod: test.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				principal = PrincipalFromContext(ctx)
+				return nil, nil
+			})
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got %v, want %v", err, test.code)
+			}
+			if test.code != codes.OK {
+				if principal != nil {
+					t.Error("denied call reached the handler")
+				}
+				return
+			}
+			if principal.UserId != test.userId || principal.IsAnonymous() != (test.userId == "") {
+				t.Errorf("handler got principal %+v, want user %q", principal, test.userId)
+			}
+		})
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	principal := &Principal{UserId: "1", TokenType: TokenTypeApiToken, Scopes: []string{"post:read", "job:write"}}
+	tests := []struct {
+		scopes []string
+		want   bool
+	}{
+		{nil, true},
+		{[]string{"post:read"}, true},
+		{[]string{"post:read", "job:write"}, true},
+		{[]string{"post:read", "post:write"}, false},
+	}
+	for _, test := range tests {
+		if got := principal.HasScopes(test.scopes); got != test.want {
+			t.Errorf("HasScopes(%v) = %v, want %v", test.scopes, got, test.want)
+		}
+	}
+}
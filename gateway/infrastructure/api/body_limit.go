@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+)
+
+// BodyLimiter limits the size of requests to the default of the configuration
+// or the maxBodySize of the method in the policy.
+type BodyLimiter struct {
+	policy       *config.Policy
+	defaultLimit config.ByteSize
+	largestLimit config.ByteSize
+}
+
+func NewBodyLimiter(c *config.Config, policy *config.Policy) *BodyLimiter {
+	return &BodyLimiter{
+		policy:       policy,
+		defaultLimit: c.MaxRequestBodySize,
+		largestLimit: policy.LargestBodySize(c.MaxRequestBodySize),
+	}
+}
+
+func (l *BodyLimiter) limit(fullMethod string) config.ByteSize {
+	if method, ok := l.policy.Method(fullMethod); ok && method.MaxBodySize > 0 {
+		return method.MaxBodySize
+	}
+	return l.defaultLimit
+}
+
+// Handler limits the bodies of HTTP requests. The limit is the default until
+// Annotate finds the gRPC method the request is routed to. Bodies declared
+// larger than any method allows are rejected without reading them.
+func (l *BodyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > int64(l.largestLimit) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, bodyTooLargeStatus(l.largestLimit))
+			return
+		}
+		r.Body = &limitedBody{body: r.Body, w: w, limit: l.defaultLimit}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Annotate is a grpc-gateway metadata annotator. It runs before the body is
+// read and sets the limit of the method the request is routed to.
+func (l *BodyLimiter) Annotate(ctx context.Context, r *http.Request) metadata.MD {
+	if body, ok := r.Body.(*limitedBody); ok {
+		if fullMethod, ok := runtime.RPCMethod(ctx); ok {
+			body.limit = l.limit(fullMethod)
+		}
+	}
+	return nil
+}
+
+// Unary applies the same limits to calls made directly over gRPC, measured by
+// their encoded size.
+func (l *BodyLimiter) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if limit := l.limit(info.FullMethod); proto.Size(msg) > int(limit) {
+			return nil, status.Error(codes.ResourceExhausted, "request is larger than "+limit.String())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// limitedBody reads the request body through http.MaxBytesReader, created on
+// the first read so the limit can still change until then, and remembers
+// whether the limit was exceeded.
+type limitedBody struct {
+	body     io.ReadCloser
+	w        http.ResponseWriter
+	limit    config.ByteSize
+	reader   io.ReadCloser
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		b.reader = http.MaxBytesReader(b.w, b.body, int64(b.limit))
+	}
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= int64(b.limit) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// bodyTooLarge returns the limit r exceeded, if any.
+func bodyTooLarge(r *http.Request) (config.ByteSize, bool) {
+	body, ok := r.Body.(*limitedBody)
+	if !ok || !body.exceeded {
+		return 0, false
+	}
+	return body.limit, true
+}
+
+func bodyTooLargeStatus(limit config.ByteSize) *status.Status {
+	return status.New(codes.ResourceExhausted, "request body is larger than "+limit.String())
+}
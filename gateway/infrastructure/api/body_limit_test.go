@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"gateway/startup/config"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// limitedMux serves POST /v1/<method> like a generated gateway handler of
+// /test.Service/<method>: it annotates the call, reads the whole body and
+// reports read failures as InvalidArgument. Bodies are limited to 16B, or 64B
+// for Upload.
+func limitedMux(t *testing.T) (http.Handler, *BodyLimiter) {
+	c := config.NewConfig()
+	c.MaxRequestBodySize = 16
+	policy := &config.Policy{Methods: map[string]*config.MethodPolicy{
+		"/test.Service/Read":   {Public: true},
+		"/test.Service/Upload": {Public: true, MaxBodySize: 64},
+	}}
+	limiter := NewBodyLimiter(c, policy)
+	mux := runtime.NewServeMux(
+		runtime.WithErrorHandler(ErrorHandler),
+		runtime.WithMetadata(limiter.Annotate),
+	)
+	err := mux.HandlePath(http.MethodPost, "/v1/{method}", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/test.Service/"+params["method"])
+		if err == nil {
+			_, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			runtime.HTTPError(ctx, mux, &runtime.JSONPb{}, w, r, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter.Handler(mux), limiter
+}
+
+func TestBodyLimiterHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		chunked bool
+		status  int
+		message string
+	}{
+		{"within the default limit", "Read", strings.Repeat("a", 16), false, http.StatusOK, ""},
+		{"over the default limit", "Read", strings.Repeat("a", 32), false, http.StatusRequestEntityTooLarge, "request body is larger than 16B"},
+		{"over the default limit without a length", "Read", strings.Repeat("a", 32), true, http.StatusRequestEntityTooLarge, "request body is larger than 16B"},
+		{"within the limit of the method", "Upload", strings.Repeat("a", 64), false, http.StatusOK, ""},
+		{"over the limit of the method", "Upload", strings.Repeat("a", 65), true, http.StatusRequestEntityTooLarge, "request body is larger than 64B"},
+		{"declared larger than any limit", "Upload", strings.Repeat("a", 65), false, http.StatusRequestEntityTooLarge, "request body is larger than 64B"},
+	}
+	handler, _ := limitedMux(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/"+test.method, strings.NewReader(test.body))
+			r.Header.Set(RequestIdHeader, "request-1")
+			if test.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.status == http.StatusOK {
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("got content type %q", contentType)
+			}
+			var body ErrorBody
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			want := ErrorBody{Code: int(codes.ResourceExhausted), Status: "ResourceExhausted", Message: test.message, RequestId: "request-1"}
+			if body.Code != want.Code || body.Status != want.Status || body.Message != want.Message || body.RequestId != want.RequestId {
+				t.Errorf("got %+v, want %+v", body, want)
+			}
+		})
+	}
+}
+
+func TestBodyLimiterUnary(t *testing.T) {
+	_, limiter := limitedMux(t)
+	descriptor := ownedDescriptor(t)
+	tests := []struct {
+		method string
+		userId string
+		code   codes.Code
+	}{
+		{"/test.Service/Read", "1", codes.OK},
+		{"/test.Service/Read", strings.Repeat("1", 32), codes.ResourceExhausted},
+		{"/test.Service/Upload", strings.Repeat("1", 32), codes.OK},
+	}
+	for _, test := range tests {
+		_, err := limiter.Unary()(context.Background(), ownedRequest(descriptor, test.userId, "", ""), &grpc.UnaryServerInfo{FullMethod: test.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s with a %d character id: got %v, want %v", test.method, len(test.userId), err, test.code)
+		}
+	}
+}
@@ -2,373 +2,153 @@ package api
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"gateway/infrastructure/clients"
 	"gateway/startup/config"
 	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
-	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
-	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/services"
-	"google.golang.org/grpc/metadata"
+	"github.com/sirupsen/logrus"
 )
 
 type ConnectionGatewayStruct struct {
 	connectionService.UnimplementedConnectionServiceServer
 	config           *config.Config
 	connectionClient connectionService.ConnectionServiceClient
-	userClient       userService.UserServiceClient
 }
 
-func NewConnectionGateway(c *config.Config) *ConnectionGatewayStruct {
+func NewConnectionGateway(c *config.Config, clients *clients.Factory) *ConnectionGatewayStruct {
 	return &ConnectionGatewayStruct{
 		config:           c,
-		connectionClient: services.NewConnectionClient(fmt.Sprintf("%s:%s", c.ConnectionServiceHost, c.ConnectionServicePort)),
-		userClient:       services.NewUserClient(fmt.Sprintf("%s:%s", c.UserServiceHost, c.UserServicePort)),
+		connectionClient: clients.Connection(),
 	}
 }
 
 func (s *ConnectionGatewayStruct) NewUserConnection(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Creating new connection for user with id: " + in.Connection.UserId + " with user with id: " + in.Connection.ConnectedUserId)
-	err := checkValue(in.String())
-	if err != nil {
-		Log.Warn("Input possibly contains malicious data")
-		return nil, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		Log.Warn("User is not authenticated")
-		return &connectionService.UserConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		Log.Warn("Current user role dont have valid permission")
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Creating new connection between users")
 	return s.connectionClient.NewUserConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) ApproveConnection(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Approving connection between users with ids: " + in.Connection.UserId + "---" + in.Connection.ConnectedUserId)
-	err := checkValue(in.String())
-	if err != nil {
-		return nil, err
-	}
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Approving connection between users")
 	return s.connectionClient.ApproveConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetConnection(ctx context.Context, in *connectionService.Connection) (*connectionService.Connection, error) {
-	Log.Info("Getting connection between users with ids: " + in.UserId + "---" + in.ConnectedUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.Connection{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.Connection{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.UserId, "connectedUserId": in.ConnectedUserId}).Info("Getting connection between users")
 	return s.connectionClient.GetConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) ApproveAllConnection(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.EmptyRequest, error) {
-	Log.Info("Approving all connections for user with id: " + in.UserId)
-	err := checkValue(in.String())
-	if err != nil {
-		return nil, err
-	}
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
-	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Approving all connections for user")
 	return s.connectionClient.ApproveAllConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) RejectConnection(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Getting connection between users with ids: " + in.Connection.UserId + "---" + in.Connection.ConnectedUserId)
-	err := checkValue(in.String())
-	if err != nil {
-		return nil, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Getting connection between users")
 	return s.connectionClient.RejectConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) DeleteConnection(ctx context.Context, in *connectionService.Connection) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Delete connection between users with ids: " + in.UserId + "---" + in.ConnectedUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_delete")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.UserId, "connectedUserId": in.ConnectedUserId}).Info("Delete connection between users")
 	return s.connectionClient.DeleteConnection(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetAllConnections(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.AllConnectionResponse, error) {
-	Log.Info("Getting all connections")
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).Info("Getting all connections")
 	return s.connectionClient.GetAllConnections(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetFollowings(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.AllConnectionResponse, error) {
-	Log.Info("Getting all following for user with id: " + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Getting all following for user")
 	return s.connectionClient.GetFollowings(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetFollowers(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.AllConnectionResponse, error) {
-	Log.Info("Getting all followers for user with id: " + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Getting all followers for user")
 	return s.connectionClient.GetFollowers(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetAllRequestConnectionsByUserId(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.AllConnectionResponse, error) {
-	Log.Info("Getting connection request for user with id: " + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Getting connection request for user")
 	return s.connectionClient.GetAllRequestConnectionsByUserId(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetAllPendingConnectionsByUserId(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.AllConnectionResponse, error) {
-	Log.Info("Getting all pending request for user with id: " + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.AllConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Getting all pending request for user")
 	return s.connectionClient.GetAllPendingConnectionsByUserId(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) BlockUser(ctx context.Context, in *connectionService.BlockUserRequest) (*connectionService.EmptyRequest, error) {
-	Log.Info("User with id: " + in.Block.UserId + "block user with id: " + in.Block.BlockUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
+	if in.Block == nil {
+		return nil, missing("block")
 	}
-	err = s.roleHavePermission(role, "block_write")
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Block.UserId, "blockUserId": in.Block.BlockUserId}).Info("User blocking user")
 	return s.connectionClient.BlockUser(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) UnblockUser(ctx context.Context, in *connectionService.BlockUserRequest) (*connectionService.EmptyRequest, error) {
-	Log.Info("User with id: " + in.Block.UserId + "unblock user with id: " + in.Block.BlockUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
-	}
-	err = s.roleHavePermission(role, "block_write")
-	if err != nil {
-		return &connectionService.EmptyRequest{}, err
+	if in.Block == nil {
+		return nil, missing("block")
 	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Block.UserId, "blockUserId": in.Block.BlockUserId}).Info("User unblocking user")
 	return s.connectionClient.UnblockUser(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) IsBlocked(ctx context.Context, in *connectionService.Block) (*connectionService.IsBlockedResponse, error) {
-	Log.Info("User with id: " + in.UserId + "check is user with id: " + in.BlockUserId + " blocked")
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.IsBlockedResponse{}, err
-	}
-	err = s.roleHavePermission(role, "block_read")
-	if err != nil {
-		return &connectionService.IsBlockedResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.UserId, "blockUserId": in.BlockUserId}).Info("Checking is user blocked")
 	return s.connectionClient.IsBlocked(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) IsBlockedAny(ctx context.Context, in *connectionService.Block) (*connectionService.IsBlockedResponse, error) {
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.IsBlockedResponse{}, err
-	}
-	err = s.roleHavePermission(role, "block_read")
-	if err != nil {
-		return &connectionService.IsBlockedResponse{}, err
-	}
 	return s.connectionClient.IsBlockedAny(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) Blocked(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.BlockedResponse, error) {
-	Log.Info("Check is user with id:" + in.UserId + " blocked")
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
-	err = s.roleHavePermission(role, "block_read")
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Check is user blocked")
 	return s.connectionClient.Blocked(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) BlockedBy(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.BlockedResponse, error) {
-	Log.Info("Checking is current user blocked by user with id:" + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
-	err = s.roleHavePermission(role, "block_read")
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Checking is current user blocked by user")
 	return s.connectionClient.BlockedBy(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) BlockedAny(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.BlockedResponse, error) {
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
-	err = s.roleHavePermission(role, "block_read")
-	if err != nil {
-		return &connectionService.BlockedResponse{}, err
-	}
 	return s.connectionClient.BlockedAny(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) ChangeMessageNotification(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Changing message notification with user id: " + in.Connection.UserId + " for user with id:" + in.Connection.ConnectedUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Changing message notification for user")
 	return s.connectionClient.ChangeMessageNotification(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) ChangePostNotification(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Changing post notification with user id: " + in.Connection.UserId + " for user with id:" + in.Connection.ConnectedUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Changing post notification for user")
 	return s.connectionClient.ChangePostNotification(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) ChangeCommentNotification(ctx context.Context, in *connectionService.UserConnectionRequest) (*connectionService.UserConnectionResponse, error) {
-	Log.Info("Changing comment notification with user id: " + in.Connection.UserId + " for user with id:" + in.Connection.ConnectedUserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
+	if in.Connection == nil {
+		return nil, missing("connection")
 	}
-	err = s.roleHavePermission(role, "connection_write")
-	if err != nil {
-		return &connectionService.UserConnectionResponse{}, err
-	}
-
+	LogFromContext(ctx).WithFields(logrus.Fields{"userId": in.Connection.UserId, "connectedUserId": in.Connection.ConnectedUserId}).Info("Changing comment notification for user")
 	return s.connectionClient.ChangeCommentNotification(ctx, in)
 }
 
 func (s *ConnectionGatewayStruct) GetAllSuggestionsByUserId(ctx context.Context, in *connectionService.UserIdRequest) (*connectionService.SuggestionsResponse, error) {
-	Log.Info("Getting suggestions request for user with id: " + in.UserId)
-	role, err := s.isUserAuthenticated(ctx)
-	if err != nil {
-		return &connectionService.SuggestionsResponse{}, err
-	}
-	err = s.roleHavePermission(role, "connection_read")
-	if err != nil {
-		return &connectionService.SuggestionsResponse{}, err
-	}
-
+	LogFromContext(ctx).WithField("userId", in.UserId).Info("Getting suggestions request for user")
 	return s.connectionClient.GetAllSuggestionsByUserId(ctx, in)
 }
-
-func (s *ConnectionGatewayStruct) isUserAuthenticated(ctx context.Context) (string, error) {
-	md, _ := metadata.FromIncomingContext(ctx)
-	jwt := md.Get("Authorization")
-	if jwt == nil {
-		Log.Warn("User dont have jwt in request")
-		return "", errors.New("unauthorized")
-	}
-	role, err := s.userClient.IsUserAuthenticated(ctx, &userService.AuthRequest{Token: jwt[0]})
-	if err != nil {
-		Log.Warn("User is not authenticated")
-		return "", errors.New("unauthorized")
-	}
-
-	return role.UserRole, nil
-}
-
-func (s *ConnectionGatewayStruct) roleHavePermission(role string, requiredPermission string) error {
-	permissions := s.config.RolePermissions[role]
-	if !contains(permissions, requiredPermission) {
-		Log.Warn("User doesn't have permission to get requests")
-		return errors.New("unauthorized")
-	}
-
-	return nil
-}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"strings"
+	"time"
+)
+
+// DeadlineInterceptor bounds how long a call may take, so calls to a backend
+// that hangs are given up with DeadlineExceeded instead of piling up.
+type DeadlineInterceptor struct {
+	policy   *config.Policy
+	backends map[string]time.Duration
+	fallback time.Duration
+	max      time.Duration
+}
+
+// NewDeadlineInterceptor fails unless every configured timeout is positive, as a
+// zero timeout would fail every call.
+func NewDeadlineInterceptor(c *config.Config, policy *config.Policy) (*DeadlineInterceptor, error) {
+	if c.BackendTimeout <= 0 {
+		return nil, fmt.Errorf("BACKEND_TIMEOUT must be positive, not %v", c.BackendTimeout)
+	}
+	if c.MaxCallTimeout <= 0 {
+		return nil, fmt.Errorf("MAX_CALL_TIMEOUT must be positive, not %v", c.MaxCallTimeout)
+	}
+	for backend, timeout := range c.BackendTimeouts {
+		if timeout <= 0 {
+			return nil, fmt.Errorf("BACKEND_TIMEOUTS of %s must be positive, not %v", backend, timeout)
+		}
+	}
+	return &DeadlineInterceptor{
+		policy:   policy,
+		backends: c.BackendTimeouts,
+		fallback: c.BackendTimeout,
+		max:      c.MaxCallTimeout,
+	}, nil
+}
+
+// Unary gives calls without a deadline the timeout of their method, or else of
+// the backend serving them. Deadlines chosen by the client, with grpc-timeout or
+// the Grpc-Timeout HTTP header, are shortened to the maximum call timeout.
+func (i *DeadlineInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		timeout := i.timeout(info.FullMethod)
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+			if timeout > i.max {
+				timeout = i.max
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+func (i *DeadlineInterceptor) timeout(fullMethod string) time.Duration {
+	if method, ok := i.policy.Method(fullMethod); ok && method.Timeout > 0 {
+		return method.Timeout
+	}
+	if timeout := i.backends[backendOf(fullMethod)]; timeout > 0 {
+		return timeout
+	}
+	return i.fallback
+}
+
+// backendOf returns the name of the backend serving fullMethod, which is the
+// proto package of its service, e.g. "user" for /user.UserService/GetRequest.
+func backendOf(fullMethod string) string {
+	service, _, _ := splitMethodName(fullMethod)
+	backend, _, _ := strings.Cut(service, ".")
+	return backend
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"testing"
+	"time"
+)
+
+// deadlineConfig gives calls 10s, calls of the user service 5s and clients at
+// most a minute.
+func deadlineConfig() *config.Config {
+	c := config.NewConfig()
+	c.BackendTimeout = 10 * time.Second
+	c.BackendTimeouts = map[string]time.Duration{"user": 5 * time.Second}
+	c.MaxCallTimeout = time.Minute
+	return c
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	policy := &config.Policy{Methods: map[string]*config.MethodPolicy{
+		"/user.UserService/SearchUsersRequest": {Timeout: 3 * time.Second},
+	}}
+	interceptor, err := NewDeadlineInterceptor(deadlineConfig(), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		client time.Duration
+		want   time.Duration
+	}{
+		{"timeout of the method before the backend", "/user.UserService/SearchUsersRequest", 0, 3 * time.Second},
+		{"timeout of the backend before the fallback", "/user.UserService/GetRequest", 0, 5 * time.Second},
+		{"fallback", "/post.PostService/GetRequest", 0, 10 * time.Second},
+		{"deadline of the client", "/user.UserService/SearchUsersRequest", 30 * time.Second, 30 * time.Second},
+		{"deadline of the client cut to the maximum", "/post.PostService/GetRequest", time.Hour, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.client > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.client)
+				defer cancel()
+			}
+			var remaining time.Duration
+			_, err := interceptor.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					t.Fatal("handler got no deadline")
+				}
+				remaining = time.Until(deadline)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining > test.want || remaining < test.want-time.Second {
+				t.Errorf("handler got %v, want %v", remaining, test.want)
+			}
+		})
+	}
+}
+
+func TestNewDeadlineInterceptorRejectsNonPositiveTimeouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config.Config)
+	}{
+		{"zero backend timeout", func(c *config.Config) { c.BackendTimeout = 0 }},
+		{"negative backend timeout", func(c *config.Config) { c.BackendTimeout = -time.Second }},
+		{"zero timeout of a backend", func(c *config.Config) { c.BackendTimeouts["user"] = 0 }},
+		{"zero max call timeout", func(c *config.Config) { c.MaxCallTimeout = 0 }},
+		{"negative max call timeout", func(c *config.Config) { c.MaxCallTimeout = -time.Minute }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := deadlineConfig()
+			test.change(c)
+			if _, err := NewDeadlineInterceptor(c, &config.Policy{}); err == nil {
+				t.Error("accepted a timeout that fails every call")
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// ErrorBody is the JSON body written by ErrorHandler for every failed request.
+type ErrorBody struct {
+	Code      int                `json:"code"`
+	Status    string             `json:"status"`
+	Message   string             `json:"message"`
+	RequestId string             `json:"requestId,omitempty"`
+	Details   []FieldErrorDetail `json:"details,omitempty"`
+}
+
+type FieldErrorDetail struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// ErrorHandler writes gRPC errors returned through the HTTP gateway as ErrorBody
+// with the HTTP status matching the gRPC code, so clients can tell an expired
+// session (401) or a missing permission (403) apart from a server failure.
+// Bodies over the size limit are answered with 413 rather than the 400 of the
+// failed decoding.
+func ErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	httpStatus := runtime.HTTPStatusFromCode(st.Code())
+	if limit, ok := bodyTooLarge(r); ok {
+		st = bodyTooLargeStatus(limit)
+		httpStatus = http.StatusRequestEntityTooLarge
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for _, header := range RateLimitHeaders {
+			if values := md.HeaderMD.Get(header); len(values) > 0 {
+				w.Header().Set(header, values[0])
+			}
+		}
+	}
+	writeError(w, r, httpStatus, st)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, httpStatus int, st *status.Status) {
+	body := ErrorBody{
+		Code:      int(st.Code()),
+		Status:    st.Code().String(),
+		Message:   st.Message(),
+		RequestId: r.Header.Get(RequestIdHeader),
+	}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, violation := range detail.FieldViolations {
+				body.Details = append(body.Details, FieldErrorDetail{Field: violation.Field, Description: violation.Description})
+			}
+		case *errdetails.RetryInfo:
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(detail.RetryDelay.AsDuration())))
+		}
+	}
+
+	if st.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		Log.Warn("Failed to write error response: " + err.Error())
+	}
+}
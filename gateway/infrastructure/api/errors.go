@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"strings"
+	"time"
+)
+
+// Errors returned by the gateway itself. They are gRPC status errors, so the
+// HTTP gateway maps them to the matching HTTP status codes.
+var (
+	ErrUnauthenticated  = status.Error(codes.Unauthenticated, "authentication required")
+	ErrPermissionDenied = status.Error(codes.PermissionDenied, "permission denied")
+	ErrTfaRequired      = status.Error(codes.Unauthenticated, "two-factor verification required")
+)
+
+// fieldViolation describes why a single request field was rejected.
+type fieldViolation struct {
+	Field       string
+	Description string
+}
+
+// invalidArgument builds an InvalidArgument error carrying the violations as
+// google.rpc.BadRequest details.
+func invalidArgument(message string, violations ...fieldViolation) error {
+	st := status.New(codes.InvalidArgument, message)
+	if len(violations) == 0 {
+		return st.Err()
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Description,
+		})
+	}
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// missing builds the InvalidArgument error for a request without the nested
+// message field, which handlers must not dereference.
+func missing(field string) error {
+	return invalidArgument(field+" is required", fieldViolation{Field: field, Description: "is required"})
+}
+
+// resourceExhausted builds a ResourceExhausted error telling the client when to
+// retry as google.rpc.RetryInfo details.
+func resourceExhausted(message string, retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, message)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// Reasons of the google.rpc.ErrorInfo details marking the errors built by
+// upstreamError.
+const (
+	errorDomain       = "gateway"
+	reasonUnavailable = "BACKEND_UNAVAILABLE"
+	reasonTimeout     = "BACKEND_TIMEOUT"
+	errorInfoService  = "service"
+)
+
+// upstreamError hides the transport details of a backend that could not be
+// reached or did not respond before the deadline of the call and reports which
+// service it was. The HTTP gateway answers these with 503 and 504. Errors it
+// built before are returned unchanged, so an outage of the user service met
+// while authenticating a call to another service names the user service.
+func upstreamError(fullMethod string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = status.FromContextError(err).Err()
+	}
+	st, ok := status.FromError(err)
+	if !ok || (st.Code() != codes.Unavailable && st.Code() != codes.DeadlineExceeded) || isUpstreamError(st) {
+		return err
+	}
+	service, _, _ := splitMethodName(fullMethod)
+	if pos := strings.LastIndex(service, "."); pos >= 0 {
+		service = service[pos+1:]
+	}
+	if st.Code() == codes.DeadlineExceeded {
+		Log.Warn(service + " did not respond in time: " + st.Message())
+		return upstreamStatus(codes.DeadlineExceeded, service+" did not respond in time", reasonTimeout, service)
+	}
+	Log.Warn(service + " is unavailable: " + st.Message())
+	return upstreamStatus(codes.Unavailable, service+" is unavailable, try again later", reasonUnavailable, service)
+}
+
+func upstreamStatus(code codes.Code, message string, reason string, service string) error {
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: map[string]string{errorInfoService: service},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// isUpstreamError reports whether st was built by upstreamError.
+func isUpstreamError(st *status.Status) bool {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == errorDomain {
+			return true
+		}
+	}
+	return false
+}
+
+// unreachable reports whether err means that a backend could not be reached or
+// did not respond in time.
+func unreachable(err error) bool {
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded)
+}
+
+// UnaryErrorInterceptor turns errors returned by handlers into the gateway error
+// model. Errors the gateway already reported for the backend that failed are
+// passed on unchanged.
+func UnaryErrorInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, upstreamError(info.FullMethod, err)
+		}
+		return resp, nil
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+// captureLog sends Log to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	previous := Log.Out
+	Log.SetOutput(&out)
+	t.Cleanup(func() {
+		Log.SetOutput(previous)
+	})
+	return &out
+}
+
+func TestUpstreamError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), codes.Unavailable, "PostService is unavailable, try again later"},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "context deadline exceeded"), codes.DeadlineExceeded, "PostService did not respond in time"},
+		{"context deadline", fmt.Errorf("calling: %w", context.DeadlineExceeded), codes.DeadlineExceeded, "PostService did not respond in time"},
+		{"other codes", status.Error(codes.NotFound, "post not found"), codes.NotFound, "post not found"},
+		{"plain errors", errors.New("failed"), codes.Unknown, "failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			st := status.Convert(upstreamError("/post.PostService/GetRequest", test.err))
+			if st.Code() != test.code || st.Message() != test.message {
+				t.Errorf("got %v %q, want %v %q", st.Code(), st.Message(), test.code, test.message)
+			}
+		})
+	}
+}
+
+func TestErrorInterceptorKeepsTheFailedBackend(t *testing.T) {
+	out := captureLog(t)
+	// The user service is down while authenticating a call to the post service.
+	authErr := upstreamError("/user.UserService/IsUserAuthenticated", status.Error(codes.Unavailable, "connection refused"))
+	_, err := UnaryErrorInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/post.PostService/GetRequest"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, authErr
+	})
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable || st.Message() != "UserService is unavailable, try again later" {
+		t.Errorf("got %v %q", st.Code(), st.Message())
+	}
+	if warnings := strings.Count(out.String(), "is unavailable"); warnings != 1 {
+		t.Errorf("outage was logged %d times, want once:\n%s", warnings, out.String())
+	}
+}
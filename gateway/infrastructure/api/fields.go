@@ -0,0 +1,58 @@
+package api
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"strings"
+)
+
+// stringField returns the value of the string field at a dotted path such as
+// "connection.userId". Path segments are matched against the JSON name of the
+// field first and its proto name second, so the paths used in the policy file
+// read the same as the HTTP request bodies.
+func stringField(msg protoreflect.Message, path string) (string, bool) {
+	segments := strings.Split(path, ".")
+	for n, segment := range segments {
+		fd := fieldByName(msg.Descriptor(), segment)
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return "", false
+		}
+		if n == len(segments)-1 {
+			if fd.Kind() != protoreflect.StringKind {
+				return "", false
+			}
+			return msg.Get(fd).String(), true
+		}
+		if fd.Message() == nil {
+			return "", false
+		}
+		msg = msg.Get(fd).Message()
+	}
+	return "", false
+}
+
+// hasStringField reports whether path resolves to a string field of md.
+func hasStringField(md protoreflect.MessageDescriptor, path string) bool {
+	segments := strings.Split(path, ".")
+	for n, segment := range segments {
+		fd := fieldByName(md, segment)
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return false
+		}
+		if n == len(segments)-1 {
+			return fd.Kind() == protoreflect.StringKind
+		}
+		if fd.Message() == nil {
+			return false
+		}
+		md = fd.Message()
+	}
+	return false
+}
+
+func fieldByName(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByJSONName(name); fd != nil {
+		return fd
+	}
+	return fields.ByName(protoreflect.Name(name))
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/http"
+)
+
+// The services the gateway serves itself have no generated code. Their service
+// descriptors and HTTP routes are written by hand using well-known types for
+// requests and responses, and go through the same interceptors as every
+// forwarded method.
+
+func newEmpty() proto.Message       { return &emptypb.Empty{} }
+func newStringValue() proto.Message { return &wrapperspb.StringValue{} }
+
+// unaryMethod describes a unary method the way generated code does.
+func unaryMethod(service string, method string, newRequest func() proto.Message, call func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	fullMethod := "/" + service + "/" + method
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newRequest()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv, ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv, ctx, req)
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+// route maps an HTTP method and path to a gRPC method. The request is built
+// from the path parameters only.
+type route struct {
+	httpMethod  string
+	path        string
+	method      string
+	newRequest  func(pathParams map[string]string) proto.Message
+	newResponse func() proto.Message
+}
+
+func emptyFromPath(map[string]string) proto.Message { return &emptypb.Empty{} }
+
+func userIdFromPath(pathParams map[string]string) proto.Message {
+	return wrapperspb.String(pathParams["userId"])
+}
+
+func registerRoutes(mux *runtime.ServeMux, conn *grpc.ClientConn, service string, routes []route) error {
+	for _, r := range routes {
+		err := mux.HandlePath(r.httpMethod, r.path, forward(mux, conn, "/"+service+"/"+r.method, r))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forward calls the gRPC method through conn like a generated gateway handler.
+func forward(mux *runtime.ServeMux, conn *grpc.ClientConn, fullMethod string, r route) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, req)
+		ctx, err := runtime.AnnotateContext(req.Context(), mux, req, fullMethod, runtime.WithHTTPPathPattern(r.path))
+		if err != nil {
+			runtime.HTTPError(req.Context(), mux, outbound, w, req, err)
+			return
+		}
+		var md runtime.ServerMetadata
+		response := r.newResponse()
+		err = conn.Invoke(ctx, fullMethod, r.newRequest(pathParams), response, grpc.Header(&md.HeaderMD), grpc.Trailer(&md.TrailerMD))
+		ctx = runtime.NewServerMetadataContext(ctx, md)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, req, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, req, response)
+	}
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	otgo "github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestIdMetadata carries the request id to the gRPC handlers and the
+// backends.
+const RequestIdMetadata = "x-request-id"
+
+// callIdMetadata links a gRPC call made by the HTTP gateway to the access log
+// entry of its HTTP request.
+const callIdMetadata = "x-gateway-call-id"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// call collects the fields of the access log entry of a single call and the
+// caller once authenticated.
+type call struct {
+	mutex     sync.Mutex
+	fields    logrus.Fields
+	principal *Principal
+}
+
+func (c *call) set(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.fields[key] = value
+}
+
+func (c *call) get(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, _ := c.fields[key].(string)
+	return value
+}
+
+func (c *call) caller() *Principal {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.principal
+}
+
+func (c *call) logger() *logrus.Entry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fields := make(logrus.Fields, len(c.fields))
+	for key, value := range c.fields {
+		fields[key] = value
+	}
+	return Log.WithFields(fields)
+}
+
+type callKey struct{}
+
+// httpCalls are the calls of HTTP requests in flight by call id.
+var httpCalls sync.Map
+
+func callFromContext(ctx context.Context) (*call, bool) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	return c, ok
+}
+
+// LogFromContext returns a logger carrying the request id, method and caller
+// of the call ctx belongs to, or Log outside of calls.
+func LogFromContext(ctx context.Context) logrus.FieldLogger {
+	c, ok := callFromContext(ctx)
+	if !ok {
+		return Log
+	}
+	return c.logger()
+}
+
+// HttpAccessLog assigns every HTTP request an X-Request-Id unless it carries a
+// valid one, returns it in the response and writes one access log entry per
+// request, completed with the caller and outcome of the gRPC call it was
+// routed to.
+func HttpAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get(RequestIdHeader)
+		if !requestIdPattern.MatchString(requestId) {
+			requestId = newId()
+			r.Header.Set(RequestIdHeader, requestId)
+		}
+		w.Header().Set(RequestIdHeader, requestId)
+
+		c := &call{fields: logrus.Fields{
+			"request_id":  requestId,
+			"http_method": r.Method,
+			"path":        r.URL.Path,
+			"client_ip":   httpClientIp(r),
+		}}
+		if traceId := traceIdFromContext(r.Context()); traceId != "" {
+			c.fields["trace_id"] = traceId
+		}
+		callId := newId()
+		httpCalls.Store(callId, c)
+		defer httpCalls.Delete(callId)
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(context.WithValue(r.Context(), callKey{}, c), callIdKey{}, callId)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		c.set("status", recorder.status)
+		c.set("bytes", recorder.bytes)
+		c.set("latency_ms", float64(time.Since(start).Microseconds())/1000)
+		c.logger().WithField("type", "access").Info(r.Method + " " + r.URL.Path)
+	})
+}
+
+type callIdKey struct{}
+
+// AnnotateCall is a grpc-gateway metadata annotator passing the call id of the
+// HTTP request to the gRPC server and recording the route it matched.
+func AnnotateCall(ctx context.Context, r *http.Request) metadata.MD {
+	callId, ok := ctx.Value(callIdKey{}).(string)
+	if !ok {
+		return nil
+	}
+	if c, ok := callFromContext(ctx); ok {
+		if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+			c.set("route", pattern)
+		}
+	}
+	return metadata.Pairs(callIdMetadata, callId)
+}
+
+// UnaryAccessLog gives every gRPC call a request id, taken from the metadata
+// when valid, and a context bound logger. Calls of HTTP requests are logged by
+// HttpAccessLog, other calls get an access log entry of their own. The request
+// id is put back into the incoming metadata so it is forwarded to the backends.
+func UnaryAccessLog() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+
+		c, fromHttp := httpCall(md)
+		requestId := firstValue(md, RequestIdMetadata)
+		if !requestIdPattern.MatchString(requestId) {
+			requestId = newId()
+		}
+		md.Set(RequestIdMetadata, requestId)
+		md.Delete(callIdMetadata)
+		if !fromHttp {
+			c = &call{fields: logrus.Fields{
+				"request_id": requestId,
+				"client_ip":  clientIp(ctx),
+			}}
+			if traceId := traceIdFromContext(ctx); traceId != "" {
+				c.fields["trace_id"] = traceId
+			}
+			err := grpc.SetHeader(ctx, metadata.Pairs(RequestIdMetadata, requestId))
+			if err != nil {
+				Log.Warn("Failed to set request id header: " + err.Error())
+			}
+		}
+		c.set("grpc_method", info.FullMethod)
+
+		ctx = context.WithValue(metadata.NewIncomingContext(ctx, md), callKey{}, c)
+		resp, err := handler(ctx, req)
+
+		c.set("grpc_code", status.Code(err).String())
+		if !fromHttp {
+			c.set("latency_ms", float64(time.Since(start).Microseconds())/1000)
+			c.logger().WithField("type", "access").Info(info.FullMethod)
+		}
+		return resp, err
+	}
+}
+
+func httpCall(md metadata.MD) (*call, bool) {
+	callId := firstValue(md, callIdMetadata)
+	if callId == "" {
+		return nil, false
+	}
+	c, ok := httpCalls.Load(callId)
+	if !ok {
+		return nil, false
+	}
+	return c.(*call), true
+}
+
+// recordPrincipal adds the caller to the access log entry of the call.
+func recordPrincipal(ctx context.Context, principal *Principal) {
+	c, ok := callFromContext(ctx)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	c.principal = principal
+	c.mutex.Unlock()
+	if principal.IsAnonymous() {
+		return
+	}
+	c.set("user_id", principal.UserId)
+	c.set("role", principal.Role)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func newId() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// traceIdFromContext returns the id of the trace of the span in ctx. Jaeger
+// span contexts print as <trace id>:<span id>:<parent id>:<flags>.
+func traceIdFromContext(ctx context.Context) string {
+	span := otgo.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	spanContext, ok := span.Context().(fmt.Stringer)
+	if !ok {
+		return ""
+	}
+	traceId, _, _ := strings.Cut(spanContext.String(), ":")
+	return traceId
+}
+
+func httpClientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
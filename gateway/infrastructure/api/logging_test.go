@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var generatedId = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// requestIdTests are incoming request ids and the id a call gets for them; an
+// empty want asks for a generated id.
+var requestIdTests = []struct {
+	name     string
+	incoming string
+	want     string
+}{
+	{"valid id", "client-id.1:2", "client-id.1:2"},
+	{"no id", "", ""},
+	{"id with spaces", "client id", ""},
+	{"id with a line break", "id\nforged=entry", ""},
+	{"id that is too long", strings.Repeat("a", 129), ""},
+}
+
+func TestHttpAccessLogRequestId(t *testing.T) {
+	for _, test := range requestIdTests {
+		t.Run(test.name, func(t *testing.T) {
+			out := captureLog(t)
+			var seen string
+			handler := HttpAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Get(RequestIdHeader)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/v1/posts", nil)
+			if test.incoming != "" {
+				r.Header.Set(RequestIdHeader, test.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(RequestIdHeader)
+			if test.want != "" && echoed != test.want || test.want == "" && !generatedId.MatchString(echoed) {
+				t.Errorf("response carries request id %q, want %q", echoed, test.want)
+			}
+			if seen != echoed {
+				t.Errorf("handler got request id %q, response carries %q", seen, echoed)
+			}
+			if !strings.Contains(out.String(), "request_id=") || !strings.Contains(out.String(), echoed) {
+				t.Errorf("access log misses the request id:\n%s", out)
+			}
+		})
+	}
+}
+
+// headerStream records the headers a gRPC handler sets.
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestUnaryAccessLogRequestId(t *testing.T) {
+	for _, test := range requestIdTests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			stream := &headerStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			if test.incoming != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIdMetadata, test.incoming))
+			}
+			var forwarded string
+			_, err := UnaryAccessLog()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Read"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				forwarded = firstValue(md, RequestIdMetadata)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			echoed := firstValue(stream.header, RequestIdMetadata)
+			if test.want != "" && echoed != test.want || test.want == "" && !generatedId.MatchString(echoed) {
+				t.Errorf("response carries request id %q, want %q", echoed, test.want)
+			}
+			if forwarded != echoed {
+				t.Errorf("backends get request id %q, response carries %q", forwarded, echoed)
+			}
+		})
+	}
+}
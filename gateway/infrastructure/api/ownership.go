@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"gateway/startup/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"sort"
+	"strings"
+)
+
+// checkOwnership rejects the request unless one of the owner fields configured
+// for the method holds the id of the authenticated user. Callers with one of the
+// owner override roles may act on any user.
+func (i *AuthInterceptor) checkOwnership(principal *Principal, method *config.MethodPolicy, req interface{}) error {
+	if len(method.Owner) == 0 || contains(i.policy.OwnerOverrideRolesFor(method), principal.Role) {
+		return nil
+	}
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ErrPermissionDenied
+	}
+	for _, path := range method.Owner {
+		userId, ok := stringField(msg.ProtoReflect(), path)
+		if ok && userId != "" && userId == principal.UserId {
+			return nil
+		}
+	}
+
+	return ErrPermissionDenied
+}
+
+// ValidateRequestFields checks that the owner, rate limit, lockout and audit
+// target fields of every method rule resolve to string fields of the method's
+// request message. Methods whose descriptors are not registered are skipped.
+func ValidateRequestFields(policy *config.Policy) error {
+	var problems []string
+	for name, method := range policy.Methods {
+		if method == nil {
+			continue
+		}
+		input, ok := requestDescriptor(name)
+		if !ok {
+			continue
+		}
+		fields := map[string][]string{"owner": method.Owner}
+		if method.RateLimit != nil {
+			for path := range method.RateLimit.Fields {
+				fields["rate limit"] = append(fields["rate limit"], path)
+			}
+		}
+		if method.Lockout != "" {
+			fields["lockout"] = []string{method.Lockout}
+		}
+		if method.Audit != nil {
+			fields["audit target"] = method.Audit.Target
+		}
+		for use, paths := range fields {
+			for _, path := range paths {
+				if !hasStringField(input, path) {
+					problems = append(problems, fmt.Sprintf("%s %s field %s is not a string field of %s", name, use, path, input.FullName()))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid policy:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// requestDescriptor looks up the request message of a full gRPC method name.
+func requestDescriptor(fullMethod string) (protoreflect.MessageDescriptor, bool) {
+	service, method, ok := splitMethodName(fullMethod)
+	if !ok {
+		return nil, false
+	}
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, false
+	}
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, false
+	}
+	methodDescriptor := serviceDescriptor.Methods().ByName(protoreflect.Name(method))
+	if methodDescriptor == nil {
+		return nil, false
+	}
+	return methodDescriptor.Input(), true
+}
+
+func splitMethodName(fullMethod string) (string, string, bool) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	pos := strings.LastIndex(name, "/")
+	if pos < 0 {
+		return "", "", false
+	}
+	return name[:pos], name[pos+1:], true
+}
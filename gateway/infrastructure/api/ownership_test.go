@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+// ownedDescriptor describes a request holding a user id and a connection
+// between two users.
+func ownedDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	field := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+		if typeName != "" {
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/owned.proto"),
+		Package: proto.String("test.owned"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Connection"), Field: []*descriptorpb.FieldDescriptorProto{field("userId", 1, ""), field("connectedUserId", 2, "")}},
+			{Name: proto.String("OwnedRequest"), Field: []*descriptorpb.FieldDescriptorProto{field("userId", 1, ""), field("connection", 2, ".test.owned.Connection")}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("OwnedRequest")
+}
+
+// ownedRequest builds a request for userId with a connection from
+// connectionUserId to connectedUserId; empty ids are left unset, as is the
+// connection when both of its ids are empty.
+func ownedRequest(descriptor protoreflect.MessageDescriptor, userId, connectionUserId, connectedUserId string) *dynamicpb.Message {
+	req := dynamicpb.NewMessage(descriptor)
+	if userId != "" {
+		req.Set(descriptor.Fields().ByName("userId"), protoreflect.ValueOfString(userId))
+	}
+	if connectionUserId != "" || connectedUserId != "" {
+		connection := req.Mutable(descriptor.Fields().ByName("connection")).Message()
+		fields := connection.Descriptor().Fields()
+		if connectionUserId != "" {
+			connection.Set(fields.ByName("userId"), protoreflect.ValueOfString(connectionUserId))
+		}
+		if connectedUserId != "" {
+			connection.Set(fields.ByName("connectedUserId"), protoreflect.ValueOfString(connectedUserId))
+		}
+	}
+	return req
+}
+
+func TestCheckOwnership(t *testing.T) {
+	descriptor := ownedDescriptor(t)
+	user := &Principal{UserId: "1", Role: "USER", TokenType: TokenTypeJwt}
+	admin := &Principal{UserId: "9", Role: "ADMIN", TokenType: TokenTypeJwt}
+	owned := &config.MethodPolicy{Owner: []string{"connection.userId", "userId"}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		method    *config.MethodPolicy
+		req       interface{}
+		allowed   bool
+	}{
+		{"owner matches", user, owned, ownedRequest(descriptor, "1", "", ""), true},
+		{"owner mismatch", user, owned, ownedRequest(descriptor, "2", "", ""), false},
+		{"nested owner matches", user, owned, ownedRequest(descriptor, "", "1", "2"), true},
+		{"nested owner mismatch", user, owned, ownedRequest(descriptor, "", "2", "1"), false},
+		{"any owner field matches", user, owned, ownedRequest(descriptor, "1", "2", ""), true},
+		{"empty owner fields", user, owned, ownedRequest(descriptor, "", "", ""), false},
+		{"empty owner field of an anonymous principal", AnonymousPrincipal, owned, ownedRequest(descriptor, "", "", ""), false},
+		{"owner override role", admin, owned, ownedRequest(descriptor, "2", "", ""), true},
+		{"method without override roles", admin, &config.MethodPolicy{Owner: []string{"userId"}, OwnerOverrideRoles: []string{}}, ownedRequest(descriptor, "2", "", ""), false},
+		{"method without owner", user, &config.MethodPolicy{}, ownedRequest(descriptor, "2", "", ""), true},
+		{"unknown owner field", user, &config.MethodPolicy{Owner: []string{"ownerId"}}, ownedRequest(descriptor, "1", "", ""), false},
+		{"request that is not a message", user, owned, "1", false},
+	}
+	interceptor := NewAuthInterceptor(testPolicy(), nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := interceptor.checkOwnership(test.principal, test.method, test.req)
+			if test.allowed && err != nil {
+				t.Errorf("got %v, want the call allowed", err)
+			}
+			if !test.allowed && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("got %v, want PermissionDenied", err)
+			}
+		})
+	}
+}
+
+func TestAuthInterceptorChecksOwnership(t *testing.T) {
+	descriptor := ownedDescriptor(t)
+	policy := testPolicy()
+	interceptor := NewAuthInterceptor(policy, testResolver(t, policy))
+	tests := []struct {
+		name  string
+		token string
+		req   *dynamicpb.Message
+		code  codes.Code
+	}{
+		{"owner", signJwt(t, "1", "USER", false), ownedRequest(descriptor, "", "1", "2"), codes.OK},
+		{"other user", signJwt(t, "2", "USER", false), ownedRequest(descriptor, "", "1", "2"), codes.PermissionDenied},
+		{"admin", signJwt(t, "9", "ADMIN", false), ownedRequest(descriptor, "", "1", "2"), codes.OK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := withCredential("authorization", "Bearer "+test.token)
+			_, err := interceptor.Unary()(ctx, test.req, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Owned"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			if code := status.Code(err); code != test.code {
+				t.Errorf("got %v, want %v", err, test.code)
+			}
+		})
+	}
+}
+
+// TestBlockListsAreOwned keeps the lists of blocked users private to the user
+// they belong to.
+func TestBlockListsAreOwned(t *testing.T) {
+	policy, err := config.LoadPolicy("../../policy.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Blocked", "BlockedBy", "BlockedAny", "IsBlocked", "IsBlockedAny"} {
+		method, ok := policy.Method("/connection.ConnectionService/" + name)
+		if !ok || len(method.Owner) == 0 {
+			t.Errorf("%s has no owner rule", name)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"gateway/domain"
+	"gateway/infrastructure/authcache"
+	"gateway/infrastructure/clients"
+	"gateway/infrastructure/verifier"
+	"gateway/startup/config"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	"github.com/XWS-BSEP-TIM1-2022/dislinkt/util/token"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// Credential types a Principal can be resolved from.
+const (
+	TokenTypeJwt      = "jwt"
+	TokenTypeApiToken = "api_token"
+)
+
+// ApiKeyHeader carries an API token. Tokens may also be sent as
+// "Authorization: ApiKey <token>".
+const ApiKeyHeader = "X-Api-Key"
+
+// Principal is the verified identity of the caller. Callers without a valid
+// credential are represented by AnonymousPrincipal, never by ids taken from an
+// unverified token.
+type Principal struct {
+	UserId    string
+	Role      string
+	TokenType string
+	// TokenId is the jti claim of a JWT, or the hash of the token when it has
+	// none.
+	TokenId    string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	TfaPending bool
+	Token      string
+	// Scopes granted to an API token; empty for JWTs.
+	Scopes []string
+}
+
+var AnonymousPrincipal = &Principal{}
+
+func (p *Principal) IsAnonymous() bool {
+	return p.TokenType == ""
+}
+
+func (p *Principal) IsApiToken() bool {
+	return p.TokenType == TokenTypeApiToken
+}
+
+// HasScopes reports whether the principal holds every one of scopes, which is
+// always the case when none are required.
+func (p *Principal) HasScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !contains(p.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller resolved by AuthInterceptor, or
+// AnonymousPrincipal when there is none.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	if !ok {
+		return AnonymousPrincipal
+	}
+	return principal
+}
+
+func contextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	recordPrincipal(ctx, principal)
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalResolver turns the credentials of an incoming call into a Principal.
+// JWTs are verified locally when a verifier is configured and by the user
+// service otherwise. API tokens are always verified by the user service.
+type PrincipalResolver struct {
+	config      *config.Config
+	policy      *config.Policy
+	verifier    *verifier.Verifier
+	authCache   *authcache.Cache
+	scopeStore  domain.ApiTokenScopeStore
+	revocations domain.RevocationStore
+	userClient  userService.UserServiceClient
+}
+
+func NewPrincipalResolver(c *config.Config, clients *clients.Factory, policy *config.Policy, jwtVerifier *verifier.Verifier, authCache *authcache.Cache, scopeStore domain.ApiTokenScopeStore, revocations domain.RevocationStore) *PrincipalResolver {
+	return &PrincipalResolver{
+		config:      c,
+		policy:      policy,
+		verifier:    jwtVerifier,
+		authCache:   authCache,
+		scopeStore:  scopeStore,
+		revocations: revocations,
+		userClient:  clients.User(),
+	}
+}
+
+// Resolve returns AnonymousPrincipal for calls without credentials and an
+// error for calls whose credentials are invalid, expired or revoked. Lookups of
+// credentials are traced in an auth.resolve span.
+func (r *PrincipalResolver) Resolve(ctx context.Context) (*Principal, error) {
+	tokenType, credential := credentialOf(ctx)
+	if tokenType == "" {
+		return AnonymousPrincipal, nil
+	}
+	span, ctx := startSpan(ctx, "auth.resolve")
+	span.SetTag(tagTokenType, tokenType)
+	var principal *Principal
+	var err error
+	switch tokenType {
+	case TokenTypeJwt:
+		principal, err = r.resolveJwt(ctx, credential)
+	case TokenTypeApiToken:
+		principal, err = r.resolveApiToken(ctx, credential)
+	}
+	finishSpan(span, err)
+	return principal, err
+}
+
+// credentialOf finds the credential of the call. Authorization values without
+// a scheme are JWTs when they have the shape of one and API tokens otherwise,
+// which is how integrations sent API tokens before the ApiKey scheme existed.
+func credentialOf(ctx context.Context) (string, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if apiKey := md.Get(ApiKeyHeader); len(apiKey) > 0 && apiKey[0] != "" {
+		return TokenTypeApiToken, strings.TrimSpace(apiKey[0])
+	}
+	authorization := md.Get("Authorization")
+	if len(authorization) == 0 || authorization[0] == "" {
+		return "", ""
+	}
+	value := strings.TrimSpace(authorization[0])
+	scheme, credential, found := strings.Cut(value, " ")
+	switch {
+	case found && strings.EqualFold(scheme, "ApiKey"):
+		return TokenTypeApiToken, strings.TrimSpace(credential)
+	case found && strings.EqualFold(scheme, "Bearer"):
+		return TokenTypeJwt, strings.TrimSpace(credential)
+	case strings.Count(value, ".") == 2:
+		return TokenTypeJwt, value
+	}
+	return TokenTypeApiToken, value
+}
+
+func (r *PrincipalResolver) resolveJwt(ctx context.Context, jwt string) (*Principal, error) {
+	if r.verifier == nil {
+		return r.resolveRemotely(ctx, jwt)
+	}
+
+	claims, err := r.verifier.Verify(jwt)
+	if err != nil {
+		LogFromContext(ctx).Warn("Rejecting token: " + err.Error())
+		return nil, ErrUnauthenticated
+	}
+	principal, err := r.principal(jwt, claims, claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkDenylist(principal)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.JwtRevocationCheck {
+		err = r.checkRevocation(ctx, jwt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return principal, nil
+}
+
+// resolveApiToken asks the user service, through the auth cache, which user the
+// API token belongs to and looks up the scopes chosen when it was created.
+// Tokens created before scopes existed get the default scopes.
+func (r *PrincipalResolver) resolveApiToken(ctx context.Context, apiToken string) (*Principal, error) {
+	entry, err := r.authCache.Load(authcache.KindApiToken, apiToken, func() (authcache.Entry, time.Time, error) {
+		userId, err := r.userClient.IsApiTokenValid(ctx, &userService.AuthRequest{Token: apiToken})
+		if err != nil {
+			return authcache.Entry{}, time.Time{}, err
+		}
+		return authcache.Entry{UserId: userId.UserId}, time.Time{}, nil
+	})
+	if unreachable(err) {
+		return nil, upstreamError("/user.UserService/IsApiTokenValid", err)
+	}
+	if err != nil || entry.UserId == "" {
+		LogFromContext(ctx).Warn("Rejecting API token")
+		return nil, ErrUnauthenticated
+	}
+
+	scopes, found, err := r.scopeStore.Get(entry.UserId)
+	if err != nil {
+		LogFromContext(ctx).WithField("userId", entry.UserId).Error("Failed to read API token scopes of user: " + err.Error())
+		return nil, status.Error(codes.Internal, "failed to read API token scopes")
+	}
+	if !found {
+		scopes = r.policy.DefaultApiTokenScopes
+	}
+	return &Principal{
+		UserId:    entry.UserId,
+		TokenType: TokenTypeApiToken,
+		Token:     apiToken,
+		Scopes:    scopes,
+	}, nil
+}
+
+// resolveRemotely asks the user service to verify the token.
+func (r *PrincipalResolver) resolveRemotely(ctx context.Context, jwt string) (*Principal, error) {
+	entry, err := r.verifyRemotely(ctx, jwt)
+	if unreachable(err) {
+		return nil, upstreamError("/user.UserService/IsUserAuthenticated", err)
+	}
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := verifier.UnverifiedClaims(jwt, r.claimNames())
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	principal, err := r.principal(jwt, claims, entry.Role)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkDenylist(principal)
+	if err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// checkDenylist rejects tokens that were logged out, either on their own or by
+// logging out their user everywhere.
+func (r *PrincipalResolver) checkDenylist(principal *Principal) error {
+	revoked, err := r.revocations.IsTokenRevoked(principal.TokenId)
+	if err != nil {
+		Log.Error("Failed to check the token denylist: " + err.Error())
+		return status.Error(codes.Internal, "failed to check the token denylist")
+	}
+	if revoked {
+		Log.WithField("userId", principal.UserId).Warn("Rejecting logged out token of user")
+		return ErrUnauthenticated
+	}
+
+	revokedAt, found, err := r.revocations.UserRevokedAt(principal.UserId)
+	if err != nil {
+		Log.Error("Failed to check the token denylist: " + err.Error())
+		return status.Error(codes.Internal, "failed to check the token denylist")
+	}
+	// iat only has second resolution, so a token issued in the second of the
+	// revocation cannot be told apart from one issued before it.
+	if found && !principal.IssuedAt.Truncate(time.Second).After(revokedAt.Truncate(time.Second)) {
+		Log.WithField("userId", principal.UserId).Warn("Rejecting token of user issued before logging out everywhere")
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// checkRevocation asks the user service whether a locally verified token is
+// still accepted. The token is trusted when the user service is unreachable, so
+// an outage of the user service does not take down the other services.
+func (r *PrincipalResolver) checkRevocation(ctx context.Context, jwt string) error {
+	_, err := r.verifyRemotely(ctx, jwt)
+	if unreachable(err) {
+		LogFromContext(ctx).Warn("User service is unavailable, skipping revocation check")
+		return nil
+	}
+	if err != nil {
+		LogFromContext(ctx).Warn("Token was revoked by the user service")
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// verifyRemotely returns the user service's verdict on jwt, remembering it in
+// the auth cache until the token expires or the cache TTL passes.
+func (r *PrincipalResolver) verifyRemotely(ctx context.Context, jwt string) (authcache.Entry, error) {
+	return r.authCache.Load(authcache.KindJwt, jwt, func() (authcache.Entry, time.Time, error) {
+		claims, err := verifier.UnverifiedClaims(jwt, r.claimNames())
+		if err != nil {
+			return authcache.Entry{}, time.Time{}, err
+		}
+		role, err := r.userClient.IsUserAuthenticated(ctx, &userService.AuthRequest{Token: jwt})
+		if err != nil {
+			return authcache.Entry{}, time.Time{}, err
+		}
+		userId, err := userIdOf(jwt, claims)
+		if err != nil {
+			return authcache.Entry{}, time.Time{}, err
+		}
+		return authcache.Entry{UserId: userId, Role: role.UserRole}, claims.ExpiresAt, nil
+	})
+}
+
+func (r *PrincipalResolver) principal(jwt string, claims *verifier.Claims, role string) (*Principal, error) {
+	userId, err := userIdOf(jwt, claims)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	// Tokens without an iat claim are assumed to have been issued with the
+	// configured lifetime.
+	issuedAt := claims.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = claims.ExpiresAt.Add(-r.config.JwtLifetime)
+	}
+	tokenId := claims.Id
+	if tokenId == "" {
+		sum := sha256.Sum256([]byte(jwt))
+		tokenId = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return &Principal{
+		UserId:     userId,
+		Role:       role,
+		TokenType:  TokenTypeJwt,
+		TokenId:    tokenId,
+		IssuedAt:   issuedAt,
+		ExpiresAt:  claims.ExpiresAt,
+		TfaPending: claims.TfaPending,
+		Token:      jwt,
+	}, nil
+}
+
+// userIdOf returns the user id claim of a verified token, falling back to the
+// util token manager for tokens that keep the id elsewhere.
+func userIdOf(jwt string, claims *verifier.Claims) (string, error) {
+	if claims.UserId != "" {
+		return claims.UserId, nil
+	}
+	userId, err := token.NewJwtManagerDislinkt(0).GetUserIdFromToken(jwt)
+	if err != nil {
+		return "", err
+	}
+	if userId == "" {
+		return "", verifier.ErrInvalidToken
+	}
+	return userId, nil
+}
+
+func (r *PrincipalResolver) claimNames() verifier.ClaimNames {
+	return verifier.ClaimNames{
+		UserId:     r.config.JwtUserIdClaim,
+		Role:       r.config.JwtRoleClaim,
+		TfaPending: r.config.JwtTfaPendingClaim,
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"gateway/infrastructure/persistence"
+	"testing"
+	"time"
+)
+
+func TestCheckDenylistAfterLoggingOutEverywhere(t *testing.T) {
+	revokedAt := time.Date(2022, 5, 1, 12, 0, 0, 700_000_000, time.UTC)
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		denied   bool
+	}{
+		{"issued the second before", revokedAt.Add(-time.Second).Truncate(time.Second), true},
+		// iat cannot tell whether the token was issued before or after the
+		// revocation within its second, so it is denied.
+		{"issued in the same second", revokedAt.Truncate(time.Second), true},
+		{"issued the second after", revokedAt.Add(time.Second).Truncate(time.Second), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			revocations := persistence.NewRevocationMemoryStore()
+			err := revocations.RevokeUser("1", revokedAt, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &PrincipalResolver{revocations: revocations}
+			err = r.checkDenylist(&Principal{UserId: "1", TokenId: "token", IssuedAt: test.issuedAt})
+			if denied := err == ErrUnauthenticated; denied != test.denied {
+				t.Errorf("denied = %v (%v), want %v", denied, err, test.denied)
+			}
+		})
+	}
+}
+
+func TestCheckDenylistLoggedOutToken(t *testing.T) {
+	revocations := persistence.NewRevocationMemoryStore()
+	revocations.RevokeToken("logged out", time.Now().Add(time.Hour))
+	r := &PrincipalResolver{revocations: revocations}
+
+	if err := r.checkDenylist(&Principal{UserId: "1", TokenId: "logged out", IssuedAt: time.Now()}); err != ErrUnauthenticated {
+		t.Errorf("logged out token: got %v, want %v", err, ErrUnauthenticated)
+	}
+	if err := r.checkDenylist(&Principal{UserId: "1", TokenId: "other", IssuedAt: time.Now()}); err != nil {
+		t.Errorf("other token: got %v", err)
+	}
+}
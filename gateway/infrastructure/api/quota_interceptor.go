@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"gateway/domain"
+	"gateway/infrastructure/metrics"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// Response headers describing the API token quota window closest to running out.
+const (
+	RateLimitLimitHeader     = "X-Ratelimit-Limit"
+	RateLimitRemainingHeader = "X-Ratelimit-Remaining"
+	RateLimitResetHeader     = "X-Ratelimit-Reset"
+)
+
+// RateLimitHeaders are passed to HTTP clients under their own names rather than
+// as Grpc-Metadata- headers.
+var RateLimitHeaders = []string{RateLimitLimitHeader, RateLimitRemainingHeader, RateLimitResetHeader}
+
+type QuotaInterceptor struct {
+	policy     *config.Policy
+	usageStore domain.ApiTokenUsageStore
+}
+
+func NewQuotaInterceptor(policy *config.Policy, usageStore domain.ApiTokenUsageStore) *QuotaInterceptor {
+	return &QuotaInterceptor{
+		policy:     policy,
+		usageStore: usageStore,
+	}
+}
+
+// Unary counts the calls made with API tokens against the quota of the token's
+// plan and rejects calls once the quota is exhausted. It must run after
+// AuthInterceptor, and after the sanitize and validation interceptors so that
+// calls they reject are not counted.
+func (i *QuotaInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal := PrincipalFromContext(ctx)
+		if !principal.IsApiToken() {
+			return handler(ctx, req)
+		}
+		name, plan := i.policy.ApiTokenPlanFor(principal.UserId)
+		if plan == nil {
+			return handler(ctx, req)
+		}
+
+		now := time.Now()
+		usage, taken, err := i.usageStore.Take(principal.UserId, now, domain.ApiTokenQuota{PerMinute: plan.PerMinute, PerDay: plan.PerDay})
+		if err != nil {
+			LogFromContext(ctx).Error("Failed to count API token usage: " + err.Error())
+			return nil, status.Error(codes.Internal, "failed to count API token usage")
+		}
+		limit, remaining, reset := quotaWindow(usage, plan)
+		err = grpc.SetHeader(ctx, metadata.Pairs(
+			RateLimitLimitHeader, strconv.FormatInt(limit, 10),
+			RateLimitRemainingHeader, strconv.FormatInt(remaining, 10),
+			RateLimitResetHeader, strconv.FormatInt(reset.Unix(), 10),
+		))
+		if err != nil {
+			LogFromContext(ctx).Warn("Failed to set rate limit headers: " + err.Error())
+		}
+		if !taken {
+			LogFromContext(ctx).WithField("userId", principal.UserId).Warn("API token of user exhausted the quota of plan " + name)
+			metrics.RateLimitHits.WithLabelValues(info.FullMethod, "quota").Inc()
+			return nil, resourceExhausted("API token quota exceeded, try again later", reset.Sub(now))
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// quotaWindow returns the limit, the remaining calls and the reset time of the
+// window with the fewest calls left.
+func quotaWindow(usage domain.ApiTokenUsage, plan *config.ApiTokenPlan) (int64, int64, time.Time) {
+	minuteRemaining := plan.PerMinute - usage.Minute
+	dayRemaining := plan.PerDay - usage.Day
+	if plan.PerDay > 0 && (plan.PerMinute <= 0 || dayRemaining < minuteRemaining) {
+		return plan.PerDay, max64(dayRemaining, 0), usage.DayStart.Add(24 * time.Hour)
+	}
+	return plan.PerMinute, max64(minuteRemaining, 0), usage.MinuteStart.Add(time.Minute)
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/metrics"
+	"gateway/infrastructure/ratelimit"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RateLimitInterceptor struct {
+	policy  *config.Policy
+	limiter *ratelimit.Limiter
+	lockout *ratelimit.Lockout
+}
+
+func NewRateLimitInterceptor(c *config.Config, policy *config.Policy) *RateLimitInterceptor {
+	return &RateLimitInterceptor{
+		policy:  policy,
+		limiter: ratelimit.NewLimiter(),
+		lockout: ratelimit.NewLockout(c.LoginLockoutThreshold, c.LoginLockoutDuration, c.LoginLockoutMaxDuration, c.LoginFailureWindow),
+	}
+}
+
+// Unary enforces the rate limits and the lockout configured for the called
+// method. It must run after AuthInterceptor, which resolves the user that
+// per-user budgets are kept for.
+func (i *RateLimitInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, ok := i.policy.Method(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+		msg, _ := req.(proto.Message)
+
+		err := i.limit(ctx, info.FullMethod, method.RateLimit, msg)
+		if err != nil {
+			return nil, err
+		}
+		if method.Lockout == "" || msg == nil {
+			return handler(ctx, req)
+		}
+
+		value, _ := stringField(msg.ProtoReflect(), method.Lockout)
+		key := info.FullMethod + "|" + strings.ToLower(value)
+		lock, ok := i.lockout.Reserve(key)
+		if !ok {
+			LogFromContext(ctx).Warn("Rejecting call to " + info.FullMethod + " for locked out " + method.Lockout)
+			metrics.RateLimitHits.WithLabelValues(info.FullMethod, "lockout").Inc()
+			return nil, resourceExhausted("too many failed attempts, try again later", lock)
+		}
+		resp, err := handler(ctx, req)
+		if err == nil {
+			i.lockout.Succeed(key)
+		} else if !isCallerFailure(err) {
+			i.lockout.Release(key)
+		} else if lock > 0 {
+			LogFromContext(ctx).Warn("Locking out " + method.Lockout + " of " + info.FullMethod + " for " + lock.String())
+		}
+		return resp, err
+	}
+}
+
+func (i *RateLimitInterceptor) limit(ctx context.Context, fullMethod string, limits *config.RateLimitPolicy, msg proto.Message) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.Ip != nil {
+		err := i.take(fullMethod+"|ip|"+clientIp(ctx), limits.Ip)
+		if err != nil {
+			LogFromContext(ctx).Warn("Client exceeded the rate limit of " + fullMethod)
+			metrics.RateLimitHits.WithLabelValues(fullMethod, "ip").Inc()
+			return err
+		}
+	}
+	if principal := PrincipalFromContext(ctx); limits.User != nil && !principal.IsAnonymous() {
+		err := i.take(fullMethod+"|user|"+principal.UserId, limits.User)
+		if err != nil {
+			LogFromContext(ctx).WithField("userId", principal.UserId).Warn("User exceeded the rate limit of " + fullMethod)
+			metrics.RateLimitHits.WithLabelValues(fullMethod, "user").Inc()
+			return err
+		}
+	}
+	if msg == nil {
+		return nil
+	}
+	for path, limit := range limits.Fields {
+		value, ok := stringField(msg.ProtoReflect(), path)
+		if !ok || value == "" {
+			continue
+		}
+		err := i.take(fullMethod+"|"+path+"|"+strings.ToLower(value), limit)
+		if err != nil {
+			LogFromContext(ctx).Warn("Exceeded the rate limit of " + fullMethod + " for " + path)
+			metrics.RateLimitHits.WithLabelValues(fullMethod, path).Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *RateLimitInterceptor) take(key string, limit *config.RateLimit) error {
+	ok, wait := i.limiter.Allow(key, limit.Requests, limit.Per)
+	if !ok {
+		return resourceExhausted("too many requests, try again in "+strconv.Itoa(retryAfterSeconds(wait))+"s", wait)
+	}
+	return nil
+}
+
+// isCallerFailure reports whether err rejects the credentials of the caller,
+// which the user service does with Unauthenticated or PermissionDenied, as
+// opposed to an unreachable backend or a malformed request. Lockouts are
+// enforced before the request is validated, so rejected requests do not count.
+func isCallerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return true
+	}
+	return false
+}
+
+// clientIp returns the address of the client. Calls forwarded by the HTTP
+// gateway carry it as the last X-Forwarded-For entry, which the gateway appends
+// itself; the header is only trusted on those loopback calls so direct gRPC
+// clients cannot choose their own address.
+func clientIp(ctx context.Context) string {
+	var peerIp string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerIp = p.Addr.String()
+		if host, _, err := net.SplitHostPort(peerIp); err == nil {
+			peerIp = host
+		}
+	}
+	if ip := net.ParseIP(peerIp); ip == nil || !ip.IsLoopback() {
+		return peerIp
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	forwarded := md.Get("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return peerIp
+	}
+	entries := strings.Split(forwarded[len(forwarded)-1], ",")
+	return strings.TrimSpace(entries[len(entries)-1])
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
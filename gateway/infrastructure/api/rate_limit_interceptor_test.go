@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+const lockoutMethod = "/test.Service/Login"
+
+// lockoutInterceptor locks the userId of calls to lockoutMethod out after
+// threshold failed attempts.
+func lockoutInterceptor(threshold int) *RateLimitInterceptor {
+	c := config.NewConfig()
+	c.LoginLockoutThreshold = threshold
+	policy := &config.Policy{Methods: map[string]*config.MethodPolicy{
+		lockoutMethod: {Public: true, Lockout: "userId"},
+	}}
+	return NewRateLimitInterceptor(c, policy)
+}
+
+func TestLockoutCountsOnlyRejectedCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		locked bool
+	}{
+		{"wrong password", status.Error(codes.Unauthenticated, "wrong password"), true},
+		{"wrong second factor", status.Error(codes.PermissionDenied, "wrong code"), true},
+		{"invalid request", status.Error(codes.InvalidArgument, "username is required"), false},
+		{"unknown user", status.Error(codes.NotFound, "user not found"), false},
+		{"backend down", status.Error(codes.Unavailable, "connection refused"), false},
+		{"backend too slow", status.Error(codes.DeadlineExceeded, "deadline exceeded"), false},
+		{"backend failure", status.Error(codes.Internal, "failed"), false},
+	}
+	descriptor := ownedDescriptor(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			interceptor := lockoutInterceptor(3)
+			var err error
+			for n := 0; n < 4; n++ {
+				_, err = interceptor.Unary()(context.Background(), ownedRequest(descriptor, "alice", "", ""), &grpc.UnaryServerInfo{FullMethod: lockoutMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, test.err
+				})
+			}
+			if locked := status.Code(err) == codes.ResourceExhausted; locked != test.locked {
+				t.Errorf("locked out = %v after %v, want %v", locked, err, test.locked)
+			}
+		})
+	}
+}
+
+func TestLockoutLimitsConcurrentAttempts(t *testing.T) {
+	captureLog(t)
+	interceptor := lockoutInterceptor(3)
+	descriptor := ownedDescriptor(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	attempts := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		<-release
+		return nil, status.Error(codes.Unauthenticated, "wrong password")
+	}
+
+	var wg sync.WaitGroup
+	codesSeen := make(chan codes.Code, 10)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := interceptor.Unary()(context.Background(), ownedRequest(descriptor, "alice", "", ""), &grpc.UnaryServerInfo{FullMethod: lockoutMethod}, handler)
+			codesSeen <- status.Code(err)
+		}()
+	}
+	// Refused attempts return at once; the others wait for release.
+	deadline := time.Now().Add(time.Second)
+	for len(codesSeen) < 7 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(codesSeen)
+
+	refused := 0
+	for code := range codesSeen {
+		if code == codes.ResourceExhausted {
+			refused++
+		}
+	}
+	if attempts != 3 || refused != 7 {
+		t.Errorf("%d attempts reached the backend and %d were refused, want 3 and 7", attempts, refused)
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"runtime/debug"
+)
+
+// errInternal is returned for calls whose handler panicked, without telling the
+// caller what went wrong.
+var errInternal = status.Error(codes.Internal, "internal error")
+
+// UnaryRecoveryInterceptor turns a panic while serving a call into an Internal
+// error and logs it with the stack, so a single bad request cannot crash the
+// gateway. It must run right after the metrics interceptor, which cannot panic
+// and counts the recovered calls, to cover the other interceptors too.
+func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(recovered))
+}
+
+// StreamRecoveryInterceptor is UnaryRecoveryInterceptor for streams.
+func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(recovered))
+}
+
+func recovered(ctx context.Context, p interface{}) error {
+	LogFromContext(ctx).WithField("stack", string(debug.Stack())).Error(fmt.Sprint("Recovered from panic: ", p))
+	return errInternal
+}
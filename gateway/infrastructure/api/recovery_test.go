@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/metrics"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestRecoveredPanicsAreCountedAsInternal(t *testing.T) {
+	captureLog(t)
+	const method = "/test.Service/Panics"
+	internal := metrics.GrpcRequests.WithLabelValues(method, codes.Internal.String())
+	before := testutil.ToFloat64(internal)
+
+	chain := grpc_middleware.ChainUnaryServer(metrics.UnaryServerInterceptor(), UnaryRecoveryInterceptor())
+	_, err := chain(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("handler failed")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got %v, want Internal", err)
+	}
+	if counted := testutil.ToFloat64(internal) - before; counted != 1 {
+		t.Errorf("counted %v Internal calls, want 1", counted)
+	}
+	if inFlight := testutil.ToFloat64(metrics.GrpcRequestsInFlight.WithLabelValues(method)); inFlight != 0 {
+		t.Errorf("%v calls still in flight", inFlight)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"gateway/infrastructure/metrics"
+	"gateway/infrastructure/sanitize"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+type SanitizeInterceptor struct {
+	scanner *sanitize.Scanner
+	content map[string]*contentSanitizer
+}
+
+// contentSanitizer rewrites the user generated content of a method instead of
+// rejecting it. The rewritten content fields are not scanned: the rewrite makes
+// their markup harmless, and what looks like SQL or a path in prose is text to
+// the services storing it. The other fields are scanned as in reject mode.
+type contentSanitizer struct {
+	mode       string
+	fields     []string
+	skipFields []string
+	rewrite    func(string) string
+	// scanner is nil when every string field is content.
+	scanner *sanitize.Scanner
+}
+
+func NewSanitizeInterceptor(policy *config.Policy) (*SanitizeInterceptor, error) {
+	detectors, err := sanitize.Lookup(policy.Sanitize.Detectors)
+	if err != nil {
+		return nil, err
+	}
+	content := map[string]*contentSanitizer{}
+	for name, method := range policy.Methods {
+		if method == nil || method.Content == nil {
+			continue
+		}
+		rewrite, err := sanitize.Rewriter(method.Content.Mode)
+		if err != nil {
+			return nil, err
+		}
+		if rewrite != nil {
+			content[name] = newContentSanitizer(method.Content, rewrite, detectors, policy.Sanitize.SkipFields)
+		}
+	}
+	return &SanitizeInterceptor{
+		scanner: sanitize.NewScanner(detectors, policy.Sanitize.SkipFields),
+		content: content,
+	}, nil
+}
+
+func newContentSanitizer(policy *config.ContentPolicy, rewrite func(string) string, detectors []sanitize.Detector, skipFields []string) *contentSanitizer {
+	sanitizer := &contentSanitizer{
+		mode:       policy.Mode,
+		fields:     policy.Fields,
+		skipFields: skipFields,
+		rewrite:    rewrite,
+	}
+	if len(policy.Fields) > 0 {
+		sanitizer.scanner = sanitize.NewScanner(detectors, append(append([]string{}, skipFields...), policy.Fields...))
+	}
+	return sanitizer
+}
+
+// Unary rejects requests with a string field that looks like an attack and
+// names the field in the error. The content of methods in strip or escape mode
+// is rewritten first and every change is logged for moderation.
+func (i *SanitizeInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+		span, _ := startSpan(ctx, "sanitize")
+		var finding *sanitize.Finding
+		if content, ok := i.content[info.FullMethod]; ok {
+			var rewrites int
+			finding, rewrites = content.apply(ctx, info.FullMethod, msg)
+			span.SetTag("gateway.content_mode", content.mode)
+			span.SetTag("gateway.rewrites", rewrites)
+		} else {
+			finding = i.scanner.Scan(msg.ProtoReflect())
+		}
+		if finding != nil {
+			LogFromContext(ctx).Warn("Input of " + info.FullMethod + " possibly contains malicious data in field " + finding.Field + ": " + finding.Detector + " " + finding.Description)
+			metrics.SanitizeRejections.WithLabelValues(info.FullMethod, finding.Detector).Inc()
+			err := invalidArgument("input possibly contains malicious data", fieldViolation{
+				Field:       finding.Field,
+				Description: "looks like " + finding.Detector + " (" + finding.Description + ")",
+			})
+			span.SetTag(tagDecision, "reject")
+			span.SetTag(tagReason, finding.Detector)
+			span.SetTag("gateway.field", finding.Field)
+			finishSpan(span, err)
+			return nil, err
+		}
+		span.SetTag(tagDecision, "accept")
+		span.Finish()
+		return handler(ctx, req)
+	}
+}
+
+// apply rewrites the content of msg and scans the other fields, returning the
+// number of fields rewritten.
+func (c *contentSanitizer) apply(ctx context.Context, method string, msg proto.Message) (*sanitize.Finding, int) {
+	rewrites := 0
+	sanitize.Rewrite(msg.ProtoReflect(), c.fields, c.skipFields, c.rewrite, func(field string, original string, rewritten string) {
+		LogFromContext(ctx).Info("Content of " + method + " rewritten in " + c.mode + " mode in field " + field + ": " + sanitize.Diff(original, rewritten))
+		metrics.ContentRewrites.WithLabelValues(method, c.mode).Inc()
+		rewrites++
+	})
+	if c.scanner == nil {
+		return nil, rewrites
+	}
+	return c.scanner.Scan(msg.ProtoReflect()), rewrites
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+// commentDescriptor describes a comment with a content field and an id.
+func commentDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	field := func(name string, number int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/comment.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Comment"), Field: []*descriptorpb.FieldDescriptorProto{field("content", 1), field("postId", 2)}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("Comment")
+}
+
+func TestSanitizeInterceptorRewritesContent(t *testing.T) {
+	const method = "/test.CommentService/CreateComment"
+	descriptor := commentDescriptor(t)
+	tests := []struct {
+		name    string
+		content *config.ContentPolicy
+		text    string
+		postId  string
+		code    codes.Code
+		want    string
+	}{
+		{"reject mode rejects prose that looks like SQL", nil, `She said "hi" or 'x'='x`, "1", codes.InvalidArgument, ""},
+		{"reject mode rejects markup", nil, "<script>alert(1)</script>", "1", codes.InvalidArgument, ""},
+		{"strip mode keeps prose that looks like SQL", &config.ContentPolicy{Mode: "strip", Fields: []string{"content"}}, `She said "hi" or 'x'='x`, "1", codes.OK, `She said "hi" or 'x'='x`},
+		{"strip mode keeps paths in content", &config.ContentPolicy{Mode: "strip", Fields: []string{"content"}}, "../../etc/passwd", "1", codes.OK, "../../etc/passwd"},
+		{"strip mode rewrites markup", &config.ContentPolicy{Mode: "strip", Fields: []string{"content"}}, "<b>hi</b><script>alert(1)</script>", "1", codes.OK, "<b>hi</b>"},
+		{"escape mode escapes markup", &config.ContentPolicy{Mode: "escape", Fields: []string{"content"}}, "<script>$where</script>", "1", codes.OK, "&lt;script&gt;$where&lt;/script&gt;"},
+		{"other fields are still scanned", &config.ContentPolicy{Mode: "strip", Fields: []string{"content"}}, "hi", "' or 1=1--", codes.InvalidArgument, ""},
+		{"other fields are still scanned for markup", &config.ContentPolicy{Mode: "escape", Fields: []string{"content"}}, "hi", "<script>", codes.InvalidArgument, ""},
+		{"every field is content when none are listed", &config.ContentPolicy{Mode: "escape"}, "<i>", "<b>", codes.OK, "&lt;i&gt;"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &config.Policy{Methods: map[string]*config.MethodPolicy{method: {Content: test.content}}}
+			interceptor, err := NewSanitizeInterceptor(policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := dynamicpb.NewMessage(descriptor)
+			req.Set(descriptor.Fields().ByName("content"), protoreflect.ValueOfString(test.text))
+			req.Set(descriptor.Fields().ByName("postId"), protoreflect.ValueOfString(test.postId))
+
+			var handled *dynamicpb.Message
+			_, err = interceptor.Unary()(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handled = req.(*dynamicpb.Message)
+				return nil, nil
+			})
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got %v (%v), want %v", code, err, test.code)
+			}
+			if test.code != codes.OK {
+				if handled != nil {
+					t.Error("rejected request reached the handler")
+				}
+				return
+			}
+			if got := handled.Get(descriptor.Fields().ByName("content")).String(); got != test.want {
+				t.Errorf("content is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	otgo "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// Span tags describing what the gateway decided about a call.
+const (
+	tagUserId     = "gateway.user_id"
+	tagRole       = "gateway.role"
+	tagTokenType  = "gateway.token_type"
+	tagPermission = "gateway.permission"
+	tagScopes     = "gateway.scopes"
+	tagDecision   = "gateway.decision"
+	tagReason     = "gateway.reason"
+)
+
+// startSpan starts a child of the span in ctx, or a new trace when there is
+// none, and returns ctx carrying it.
+func startSpan(ctx context.Context, operation string) (otgo.Span, context.Context) {
+	return otgo.StartSpanFromContext(ctx, operation)
+}
+
+// finishSpan marks span failed when err is not nil and finishes it.
+func finishSpan(span otgo.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.String("event", "error"), otlog.String("message", err.Error()))
+	}
+	span.Finish()
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	otgo "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"testing"
+)
+
+// tracing makes a mock tracer the global tracer for the rest of the test.
+func tracing(t *testing.T) *mocktracer.MockTracer {
+	tracer := mocktracer.New()
+	previous := otgo.GlobalTracer()
+	otgo.SetGlobalTracer(tracer)
+	t.Cleanup(func() {
+		otgo.SetGlobalTracer(previous)
+	})
+	return tracer
+}
+
+func TestAuthInterceptorTagsTheDecision(t *testing.T) {
+	user := signJwt(t, "1", "USER", false)
+	descriptor := ownedDescriptor(t)
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		tags   map[string]interface{}
+	}{
+		{"anonymous call", "/test.Service/Public", "", "", map[string]interface{}{
+			tagDecision: "anonymous",
+		}},
+		{"allowed call", "/test.Service/Read", "authorization", "Bearer " + user, map[string]interface{}{
+			tagDecision: "allow", tagUserId: "1", tagRole: "USER", tagTokenType: TokenTypeJwt, tagPermission: "post_read",
+		}},
+		{"allowed API token", "/test.Service/Scoped", ApiKeyHeader, "reader", map[string]interface{}{
+			tagDecision: "allow", tagUserId: "1", tagTokenType: TokenTypeApiToken, tagScopes: "post:read",
+		}},
+		{"method missing from the policy", "/test.Service/Unknown", "authorization", "Bearer " + user, map[string]interface{}{
+			tagDecision: "deny", tagReason: "denied", "error": true,
+		}},
+		{"missing credentials", "/test.Service/Read", "", "", map[string]interface{}{
+			tagDecision: "deny", tagReason: "missing_credentials", "error": true,
+		}},
+		{"invalid credentials", "/test.Service/Read", ApiKeyHeader, "unknown", map[string]interface{}{
+			tagDecision: "deny", tagReason: "invalid_credentials", "error": true,
+		}},
+		{"pending two-factor verification", "/test.Service/Read", "authorization", "Bearer " + signJwt(t, "1", "USER", true), map[string]interface{}{
+			tagDecision: "deny", tagReason: "tfa_required", tagUserId: "1",
+		}},
+		{"missing permission", "/test.Service/Write", "authorization", "Bearer " + user, map[string]interface{}{
+			tagDecision: "deny", tagReason: "permission_denied", tagUserId: "1", tagPermission: "post_write", "error": true,
+		}},
+		{"foreign owner", "/test.Service/Owned", "authorization", "Bearer " + signJwt(t, "2", "USER", false), map[string]interface{}{
+			tagDecision: "deny", tagReason: "not_owner", tagUserId: "2",
+		}},
+	}
+	policy := testPolicy()
+	interceptor := NewAuthInterceptor(policy, testResolver(t, policy))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureLog(t)
+			tracer := tracing(t)
+			callSpan := tracer.StartSpan("call")
+			ctx := otgo.ContextWithSpan(withCredential(test.header, test.value), callSpan)
+			interceptor.Unary()(ctx, ownedRequest(descriptor, "1", "", ""), &grpc.UnaryServerInfo{FullMethod: test.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+
+			var auth *mocktracer.MockSpan
+			for _, span := range tracer.FinishedSpans() {
+				if span.OperationName == "auth" {
+					auth = span
+				}
+			}
+			if auth == nil {
+				t.Fatalf("finished spans %v, want an auth span", tracer.FinishedSpans())
+			}
+			if auth.ParentID != callSpan.(*mocktracer.MockSpan).SpanContext.SpanID {
+				t.Error("auth span is not a child of the call span")
+			}
+			for tag, want := range test.tags {
+				if got := auth.Tag(tag); got != want {
+					t.Errorf("tag %s is %v, want %v", tag, got, want)
+				}
+			}
+			if _, ok := test.tags[tagReason]; !ok && auth.Tag(tagReason) != nil {
+				t.Errorf("tagged reason %v", auth.Tag(tagReason))
+			}
+			if userId, ok := test.tags[tagUserId]; ok && callSpan.(*mocktracer.MockSpan).Tag(tagUserId) != userId {
+				t.Errorf("call span is tagged with user %v, want %v", callSpan.(*mocktracer.MockSpan).Tag(tagUserId), userId)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"gateway/domain"
+	"gateway/startup/config"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// UsageServiceServer reports how much API tokens are used. It is meant for
+// administrators.
+type UsageServiceServer interface {
+	// GetApiTokenUsage returns the usage of the API token of the user whose id
+	// is the value of the request.
+	GetApiTokenUsage(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	// ListApiTokenUsage returns the usage of every API token used so far.
+	ListApiTokenUsage(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+}
+
+type UsageGatewayStruct struct {
+	policy     *config.Policy
+	usageStore domain.ApiTokenUsageStore
+}
+
+func NewUsageGateway(policy *config.Policy, usageStore domain.ApiTokenUsageStore) *UsageGatewayStruct {
+	return &UsageGatewayStruct{
+		policy:     policy,
+		usageStore: usageStore,
+	}
+}
+
+func (s *UsageGatewayStruct) GetApiTokenUsage(ctx context.Context, in *wrapperspb.StringValue) (*structpb.Struct, error) {
+	LogFromContext(ctx).WithField("userId", in.GetValue()).Info("Getting API token usage of user")
+	if in.GetValue() == "" {
+		return nil, invalidArgument("user id is required", fieldViolation{Field: "userId", Description: "must not be empty"})
+	}
+	usage, _, err := s.usageStore.Get(in.GetValue())
+	if err != nil {
+		LogFromContext(ctx).Error("Failed to read API token usage: " + err.Error())
+		return nil, status.Error(codes.Internal, "failed to read API token usage")
+	}
+	return structpb.NewStruct(s.usageReport(in.GetValue(), usage))
+}
+
+func (s *UsageGatewayStruct) ListApiTokenUsage(ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error) {
+	LogFromContext(ctx).Info("Getting API token usage of all users")
+	all, err := s.usageStore.GetAll()
+	if err != nil {
+		LogFromContext(ctx).Error("Failed to read API token usage: " + err.Error())
+		return nil, status.Error(codes.Internal, "failed to read API token usage")
+	}
+	userIds := make([]string, 0, len(all))
+	for userId := range all {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	reports := make([]interface{}, 0, len(userIds))
+	for _, userId := range userIds {
+		reports = append(reports, s.usageReport(userId, all[userId]))
+	}
+	return structpb.NewStruct(map[string]interface{}{"usage": reports})
+}
+
+func (s *UsageGatewayStruct) usageReport(userId string, usage domain.ApiTokenUsage) map[string]interface{} {
+	name, plan := s.policy.ApiTokenPlanFor(userId)
+	if plan == nil {
+		plan = &config.ApiTokenPlan{}
+	}
+	report := map[string]interface{}{
+		"userId": userId,
+		"plan":   name,
+		"minute": window(usage.Minute, plan.PerMinute, usage.MinuteStart.Add(time.Minute)),
+		"day":    window(usage.Day, plan.PerDay, usage.DayStart.Add(24*time.Hour)),
+		"total":  float64(usage.Total),
+	}
+	if !usage.LastUsed.IsZero() {
+		report["lastUsed"] = usage.LastUsed.UTC().Format(time.RFC3339)
+	}
+	return report
+}
+
+func window(used int64, limit int64, resetAt time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"used":    float64(used),
+		"limit":   float64(limit),
+		"resetAt": resetAt.UTC().Format(time.RFC3339),
+	}
+}
+
+const usageServiceName = "gateway.UsageService"
+
+var usageServiceDesc = grpc.ServiceDesc{
+	ServiceName: usageServiceName,
+	HandlerType: (*UsageServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(usageServiceName, "GetApiTokenUsage", newStringValue, func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(UsageServiceServer).GetApiTokenUsage(ctx, req.(*wrapperspb.StringValue))
+		}),
+		unaryMethod(usageServiceName, "ListApiTokenUsage", newEmpty, func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(UsageServiceServer).ListApiTokenUsage(ctx, req.(*emptypb.Empty))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func RegisterUsageServiceServer(s *grpc.Server, srv UsageServiceServer) {
+	s.RegisterService(&usageServiceDesc, srv)
+}
+
+// RegisterUsageServiceHandler exposes the usage service over HTTP:
+//
+//	GET /admin/api-tokens/usage           ListApiTokenUsage
+//	GET /admin/api-tokens/{userId}/usage  GetApiTokenUsage
+func RegisterUsageServiceHandler(mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return registerRoutes(mux, conn, usageServiceName, []route{
+		{http.MethodGet, "/admin/api-tokens/usage", "ListApiTokenUsage", emptyFromPath, newStruct},
+		{http.MethodGet, "/admin/api-tokens/{userId}/usage", "GetApiTokenUsage", userIdFromPath, newStruct},
+	})
+}
+
+func newStruct() proto.Message { return &structpb.Struct{} }
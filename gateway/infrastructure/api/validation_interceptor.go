@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxViolations bounds the field violations reported for a single request.
+const maxViolations = 20
+
+var objectIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+
+type ValidationInterceptor struct {
+	defaults config.FieldDefaults
+	messages map[protoreflect.FullName]map[string]*fieldRule
+}
+
+type fieldRule struct {
+	*config.FieldRule
+	pattern *regexp.Regexp
+}
+
+func NewValidationInterceptor(rules *config.ValidationRules) *ValidationInterceptor {
+	messages := map[protoreflect.FullName]map[string]*fieldRule{}
+	for message, fields := range rules.Messages {
+		compiled := map[string]*fieldRule{}
+		for field, rule := range fields {
+			if rule == nil {
+				continue
+			}
+			compiled[field] = &fieldRule{FieldRule: rule}
+			if rule.Pattern != "" {
+				compiled[field].pattern = regexp.MustCompile(`^(?:` + rule.Pattern + `)$`)
+			}
+		}
+		messages[protoreflect.FullName(message)] = compiled
+	}
+	return &ValidationInterceptor{
+		defaults: rules.Defaults,
+		messages: messages,
+	}
+}
+
+// Unary rejects requests breaking the validation rules with every violated
+// field in the error.
+func (i *ValidationInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+		violations := i.validate(msg.ProtoReflect(), "", nil)
+		if len(violations) > 0 {
+			return nil, invalidArgument("invalid request", violations...)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (i *ValidationInterceptor) validate(msg protoreflect.Message, prefix string, violations []fieldViolation) []fieldViolation {
+	rules := i.messages[msg.Descriptor().FullName()]
+	fields := msg.Descriptor().Fields()
+	for n := 0; n < fields.Len() && len(violations) < maxViolations; n++ {
+		fd := fields.Get(n)
+		path := prefix + fd.JSONName()
+		rule := rules[fd.JSONName()]
+		if rule == nil {
+			rule = rules[string(fd.Name())]
+		}
+		if !msg.Has(fd) {
+			if rule != nil && rule.Required {
+				violations = append(violations, fieldViolation{Field: path, Description: "is required"})
+			}
+			continue
+		}
+		value := msg.Get(fd)
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				continue
+			}
+			value.Map().Range(func(key protoreflect.MapKey, mapValue protoreflect.Value) bool {
+				violations = i.validate(mapValue.Message(), fmt.Sprintf("%s[%v].", path, key.Interface()), violations)
+				return len(violations) < maxViolations
+			})
+		case fd.IsList():
+			list := value.List()
+			if description, ok := checkLength(list.Len(), rule, 0, "items"); !ok {
+				violations = append(violations, fieldViolation{Field: path, Description: description})
+				continue
+			}
+			for element := 0; element < list.Len() && len(violations) < maxViolations; element++ {
+				violations = i.validateValue(fd, list.Get(element), rule, fmt.Sprintf("%s[%d]", path, element), true, violations)
+			}
+		default:
+			violations = i.validateValue(fd, value, rule, path, false, violations)
+		}
+	}
+	return violations
+}
+
+// validateValue checks a single value of fd. The lengths of a list rule apply
+// to the list rather than its elements.
+func (i *ValidationInterceptor) validateValue(fd protoreflect.FieldDescriptor, value protoreflect.Value, rule *fieldRule, path string, element bool, violations []fieldViolation) []fieldViolation {
+	if fd.Message() != nil {
+		return i.validate(value.Message(), path+".", violations)
+	}
+	description, ok := i.check(fd, value, rule, element)
+	if !ok {
+		violations = append(violations, fieldViolation{Field: path, Description: description})
+	}
+	return violations
+}
+
+func (i *ValidationInterceptor) check(fd protoreflect.FieldDescriptor, value protoreflect.Value, rule *fieldRule, element bool) (string, bool) {
+	lengthRule := rule
+	if element {
+		lengthRule = nil
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s := value.String()
+		if description, ok := checkLength(utf8.RuneCountInString(s), lengthRule, i.defaults.MaxLength, "characters"); !ok {
+			return description, false
+		}
+		if rule == nil {
+			return "", true
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(s) {
+			return "must match " + rule.Pattern, false
+		}
+		switch rule.Format {
+		case config.FormatObjectId:
+			if !objectIdPattern.MatchString(s) {
+				return "must be a valid id", false
+			}
+		case config.FormatEmail:
+			if address, err := mail.ParseAddress(s); err != nil || address.Address != s {
+				return "must be a valid email address", false
+			}
+		}
+	case protoreflect.BytesKind:
+		return checkLength(len(value.Bytes()), lengthRule, 0, "bytes")
+	case protoreflect.EnumKind:
+		number := value.Enum()
+		if i.defaults.DefinedEnums && fd.Enum().Values().ByNumber(number) == nil {
+			return "is not a valid " + string(fd.Enum().Name()), false
+		}
+		return checkRange(float64(number), rule)
+	case protoreflect.BoolKind:
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return checkRange(value.Float(), rule)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return checkRange(float64(value.Uint()), rule)
+	default:
+		return checkRange(float64(value.Int()), rule)
+	}
+	return "", true
+}
+
+func checkLength(length int, rule *fieldRule, defaultMax int, unit string) (string, bool) {
+	max := defaultMax
+	if rule != nil && rule.MaxLength > 0 {
+		max = rule.MaxLength
+	}
+	if max > 0 && length > max {
+		return "must have at most " + strconv.Itoa(max) + " " + unit, false
+	}
+	if rule != nil && length < rule.MinLength {
+		return "must have at least " + strconv.Itoa(rule.MinLength) + " " + unit, false
+	}
+	return "", true
+}
+
+func checkRange(number float64, rule *fieldRule) (string, bool) {
+	if rule == nil {
+		return "", true
+	}
+	if rule.Min != nil && number < *rule.Min {
+		return "must be at least " + strconv.FormatFloat(*rule.Min, 'f', -1, 64), false
+	}
+	if rule.Max != nil && number > *rule.Max {
+		return "must be at most " + strconv.FormatFloat(*rule.Max, 'f', -1, 64), false
+	}
+	return "", true
+}
+
+// ValidateRuleFields checks that the validation rules name fields of their
+// messages and only use constraints that fit the field type. It returns the
+// messages with rules that are not in the proto registry.
+func ValidateRuleFields(rules *config.ValidationRules) ([]string, error) {
+	var problems []string
+	var unknown []string
+	for message, fields := range rules.Messages {
+		descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(message))
+		if err != nil {
+			unknown = append(unknown, message)
+			continue
+		}
+		md, ok := descriptor.(protoreflect.MessageDescriptor)
+		if !ok {
+			problems = append(problems, message+" is not a message")
+			continue
+		}
+		for field, rule := range fields {
+			fd := fieldByName(md, field)
+			if fd == nil {
+				problems = append(problems, field+" is not a field of "+message)
+				continue
+			}
+			if rule == nil {
+				continue
+			}
+			if problem := ruleMismatch(fd, rule); problem != "" {
+				problems = append(problems, message+"."+field+" "+problem)
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return unknown, fmt.Errorf("invalid validation rules:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return unknown, nil
+}
+
+func ruleMismatch(fd protoreflect.FieldDescriptor, rule *config.FieldRule) string {
+	if fd.IsMap() {
+		if rule.MinLength > 0 || rule.MaxLength > 0 || rule.Pattern != "" || rule.Format != "" || rule.Min != nil || rule.Max != nil {
+			return "is a map and only supports required"
+		}
+		return ""
+	}
+	kind := fd.Kind()
+	if (rule.Pattern != "" || rule.Format != "") && kind != protoreflect.StringKind {
+		return "has a pattern or format but is not a string"
+	}
+	if (rule.MinLength > 0 || rule.MaxLength > 0) && !fd.IsList() && kind != protoreflect.StringKind && kind != protoreflect.BytesKind {
+		return "has a length but is not a string, bytes or list"
+	}
+	if (rule.Min != nil || rule.Max != nil) && (kind == protoreflect.StringKind || kind == protoreflect.BytesKind || kind == protoreflect.BoolKind || kind == protoreflect.MessageKind || kind == protoreflect.GroupKind) {
+		return "has a min or max but is not a number or enum"
+	}
+	return ""
+}
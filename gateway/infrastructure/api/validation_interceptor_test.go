@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"gateway/startup/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	signupFile     protoreflect.FileDescriptor
+	signupFileOnce sync.Once
+)
+
+// signupDescriptors registers and returns test.validation.SignupRequest, which
+// holds an Account with a field of every kind the rules constrain. It is
+// registered so ValidateRuleFields can find it.
+func signupDescriptors(t *testing.T) (request, account protoreflect.MessageDescriptor) {
+	signupFileOnce.Do(func() {
+		field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+			label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+			if repeated {
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			}
+			fd := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(name),
+				JsonName: proto.String(name),
+				Number:   proto.Int32(number),
+				Label:    label.Enum(),
+				Type:     kind.Enum(),
+			}
+			if typeName != "" {
+				fd.TypeName = proto.String(typeName)
+			}
+			return fd
+		}
+		userId := field("user_id", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false)
+		userId.JsonName = proto.String("userId")
+		file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+			Name:    proto.String("test/validation.proto"),
+			Package: proto.String("test.validation"),
+			Syntax:  proto.String("proto3"),
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("Role"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("USER"), Number: proto.Int32(0)},
+					{Name: proto.String("ADMIN"), Number: proto.Int32(1)},
+				},
+			}},
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Account"), Field: []*descriptorpb.FieldDescriptorProto{
+					field("username", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+					field("email", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+					field("age", 3, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false),
+					field("skills", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+					field("role", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.validation.Role", false),
+					userId,
+				}},
+				{Name: proto.String("SignupRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+					field("account", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.validation.Account", false),
+					field("referrers", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.validation.Account", true),
+				}},
+			},
+		}, protoregistry.GlobalFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := protoregistry.GlobalFiles.RegisterFile(file); err != nil {
+			t.Fatal(err)
+		}
+		signupFile = file
+	})
+	return signupFile.Messages().ByName("SignupRequest"), signupFile.Messages().ByName("Account")
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+var signupRules = &config.ValidationRules{
+	Defaults: config.FieldDefaults{MaxLength: 24, DefinedEnums: true},
+	Messages: map[string]map[string]*config.FieldRule{
+		"test.validation.SignupRequest": {
+			"account": {Required: true},
+		},
+		"test.validation.Account": {
+			"username": {Required: true, MinLength: 3, MaxLength: 8, Pattern: `[a-z]+`},
+			"email":    {Format: config.FormatEmail},
+			"age":      {Min: float(13), Max: float(120)},
+			"skills":   {MaxLength: 2},
+			"user_id":  {Format: config.FormatObjectId},
+		},
+	},
+}
+
+// account builds a valid account and applies change to it.
+func account(descriptor protoreflect.MessageDescriptor, change func(account *dynamicpb.Message)) *dynamicpb.Message {
+	fields := descriptor.Fields()
+	msg := dynamicpb.NewMessage(descriptor)
+	msg.Set(fields.ByName("username"), protoreflect.ValueOfString("alice"))
+	msg.Set(fields.ByName("email"), protoreflect.ValueOfString("alice@example.com"))
+	msg.Set(fields.ByName("age"), protoreflect.ValueOfInt32(30))
+	msg.Set(fields.ByName("user_id"), protoreflect.ValueOfString("62a0c5f1e4b0a1b2c3d4e5f6"))
+	if change != nil {
+		change(msg)
+	}
+	return msg
+}
+
+func TestValidationRejectsBrokenRules(t *testing.T) {
+	requestDescriptor, accountDescriptor := signupDescriptors(t)
+	fields := accountDescriptor.Fields()
+	set := func(name string, value protoreflect.Value) func(*dynamicpb.Message) {
+		return func(msg *dynamicpb.Message) {
+			msg.Set(fields.ByName(protoreflect.Name(name)), value)
+		}
+	}
+	skills := func(skills ...string) func(*dynamicpb.Message) {
+		return func(msg *dynamicpb.Message) {
+			list := msg.Mutable(fields.ByName("skills")).List()
+			for _, skill := range skills {
+				list.Append(protoreflect.ValueOfString(skill))
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request func() *dynamicpb.Message
+		field   string
+		want    string
+	}{
+		{"valid", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, skills("go", "sql")))
+		}, "", ""},
+		{"missing nested message", func() *dynamicpb.Message {
+			return dynamicpb.NewMessage(requestDescriptor)
+		}, "account", "is required"},
+		{"missing string", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, func(msg *dynamicpb.Message) { msg.Clear(fields.ByName("username")) }))
+		}, "account.username", "is required"},
+		{"too short", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("username", protoreflect.ValueOfString("al"))))
+		}, "account.username", "must have at least 3 characters"},
+		{"too long", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("username", protoreflect.ValueOfString("alexandria"))))
+		}, "account.username", "must have at most 8 characters"},
+		{"length counts characters", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("username", protoreflect.ValueOfString("ćđčšž"))))
+		}, "account.username", "must match [a-z]+"},
+		{"pattern must match the whole value", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("username", protoreflect.ValueOfString("alice1"))))
+		}, "account.username", "must match [a-z]+"},
+		{"default max length", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("email", protoreflect.ValueOfString("alice.liddell@example.com"))))
+		}, "account.email", "must have at most 24 characters"},
+		{"email", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("email", protoreflect.ValueOfString("alice@"))))
+		}, "account.email", "must be a valid email address"},
+		{"email with a display name", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("email", protoreflect.ValueOfString("Al <a@b.co>"))))
+		}, "account.email", "must be a valid email address"},
+		{"object id", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("user_id", protoreflect.ValueOfString("1"))))
+		}, "account.userId", "must be a valid id"},
+		{"below min", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("age", protoreflect.ValueOfInt32(12))))
+		}, "account.age", "must be at least 13"},
+		{"above max", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("age", protoreflect.ValueOfInt32(121))))
+		}, "account.age", "must be at most 120"},
+		{"undefined enum", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, set("role", protoreflect.ValueOfEnum(7))))
+		}, "account.role", "is not a valid Role"},
+		{"too many items", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, skills("go", "sql", "yaml")))
+		}, "account.skills", "must have at most 2 items"},
+		{"list items get the default length", func() *dynamicpb.Message {
+			return signup(requestDescriptor, account(accountDescriptor, skills("distributed systems design")))
+		}, "account.skills[0]", "must have at most 24 characters"},
+		{"rules apply to messages in lists", func() *dynamicpb.Message {
+			request := signup(requestDescriptor, account(accountDescriptor, nil))
+			referrers := request.Mutable(requestDescriptor.Fields().ByName("referrers")).List()
+			referrers.Append(protoreflect.ValueOfMessage(account(accountDescriptor, nil)))
+			referrers.Append(protoreflect.ValueOfMessage(account(accountDescriptor, set("age", protoreflect.ValueOfInt32(1)))))
+			return request
+		}, "referrers[1].age", "must be at least 13"},
+	}
+	interceptor := NewValidationInterceptor(signupRules)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled := false
+			_, err := interceptor.Unary()(context.Background(), test.request(), &grpc.UnaryServerInfo{FullMethod: "/test.validation.Service/Signup"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handled = true
+				return nil, nil
+			})
+			if test.field == "" {
+				if err != nil || !handled {
+					t.Fatalf("valid request was rejected: %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("got %v, want InvalidArgument", err)
+			}
+			if handled {
+				t.Error("rejected request reached the handler")
+			}
+			violations := violationsOf(err)
+			if violations[test.field] != test.want {
+				t.Errorf("got violations %v, want %s %q", violations, test.field, test.want)
+			}
+		})
+	}
+}
+
+func TestValidationReportsEveryViolation(t *testing.T) {
+	requestDescriptor, accountDescriptor := signupDescriptors(t)
+	fields := accountDescriptor.Fields()
+	request := signup(requestDescriptor, account(accountDescriptor, func(msg *dynamicpb.Message) {
+		msg.Clear(fields.ByName("username"))
+		msg.Set(fields.ByName("email"), protoreflect.ValueOfString("alice"))
+		msg.Set(fields.ByName("age"), protoreflect.ValueOfInt32(200))
+	}))
+	_, err := NewValidationInterceptor(signupRules).Unary()(context.Background(), request, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	violations := violationsOf(err)
+	for _, field := range []string{"account.username", "account.email", "account.age"} {
+		if _, ok := violations[field]; !ok {
+			t.Errorf("%s is missing from the violations %v", field, violations)
+		}
+	}
+}
+
+func TestValidateRuleFields(t *testing.T) {
+	signupDescriptors(t)
+	tests := []struct {
+		name     string
+		messages map[string]map[string]*config.FieldRule
+		problem  string
+		unknown  []string
+	}{
+		{"known fields by JSON and proto name", signupRules.Messages, "", nil},
+		{"unknown field", map[string]map[string]*config.FieldRule{
+			"test.validation.Account": {"nickname": {Required: true}},
+		}, "nickname is not a field of test.validation.Account", nil},
+		{"not a message", map[string]map[string]*config.FieldRule{
+			"test.validation.Role": {"USER": {Required: true}},
+		}, "test.validation.Role is not a message", nil},
+		{"format on a number", map[string]map[string]*config.FieldRule{
+			"test.validation.Account": {"age": {Format: config.FormatEmail}},
+		}, "test.validation.Account.age has a pattern or format but is not a string", nil},
+		{"min on a string", map[string]map[string]*config.FieldRule{
+			"test.validation.Account": {"email": {Min: float(1)}},
+		}, "test.validation.Account.email has a min or max but is not a number or enum", nil},
+		{"length on a message", map[string]map[string]*config.FieldRule{
+			"test.validation.SignupRequest": {"account": {MaxLength: 1}},
+		}, "test.validation.SignupRequest.account has a length but is not a string, bytes or list", nil},
+		{"unknown message", map[string]map[string]*config.FieldRule{
+			"test.validation.Missing": {"id": {Required: true}},
+		}, "", []string{"test.validation.Missing"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unknown, err := ValidateRuleFields(&config.ValidationRules{Messages: test.messages})
+			if test.problem == "" && err != nil {
+				t.Errorf("got %v", err)
+			}
+			if test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)) {
+				t.Errorf("got %v, want %q", err, test.problem)
+			}
+			if strings.Join(unknown, ",") != strings.Join(test.unknown, ",") {
+				t.Errorf("unknown messages are %v, want %v", unknown, test.unknown)
+			}
+		})
+	}
+}
+
+func TestValidationFileLoads(t *testing.T) {
+	if _, err := config.LoadValidationRules("../../validation.yml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signup(descriptor protoreflect.MessageDescriptor, account *dynamicpb.Message) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(descriptor)
+	msg.Set(descriptor.Fields().ByName("account"), protoreflect.ValueOfMessage(account))
+	return msg
+}
+
+// violationsOf maps the fields in the BadRequest details of err to their
+// descriptions.
+func violationsOf(err error) map[string]string {
+	violations := map[string]string{}
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				violations[violation.Field] = violation.Description
+			}
+		}
+	}
+	return violations
+}
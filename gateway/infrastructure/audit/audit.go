@@ -0,0 +1,270 @@
+// Package audit keeps an append-only log of security sensitive operations.
+//
+// Every entry is one JSON line. Entries are numbered and each holds the hash of
+// the entry before it, so removing, reordering or editing entries breaks the
+// chain, which Verify reports. The hashes are HMACs under a secret key, so
+// whoever can write the log but does not hold the key cannot rewrite the chain
+// after an edit. Cutting entries off the end of the log leaves an intact chain;
+// the sequence number and hash of the last entry are therefore written to the
+// gateway log periodically by AnchorEvery and when the audit log is closed.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Genesis is the previous hash of the first entry.
+const Genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Outcomes of audited operations. Failed operations record the gRPC code too.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Entry is a single audited operation. ActorId is empty for anonymous callers.
+// Target maps request fields to the ids of the users or objects acted on.
+type Entry struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Action    string            `json:"action"`
+	Method    string            `json:"method"`
+	ActorId   string            `json:"actorId,omitempty"`
+	ActorRole string            `json:"actorRole,omitempty"`
+	Target    map[string]string `json:"target,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Code      string            `json:"code"`
+	ClientIp  string            `json:"clientIp,omitempty"`
+	RequestId string            `json:"requestId,omitempty"`
+	Prev      string            `json:"prev"`
+	Hash      string            `json:"hash"`
+}
+
+// hash returns the HMAC-SHA256 of the entry under key, which covers every
+// field but Hash.
+func (e Entry) hash(key []byte) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Log appends entries to the audit log file.
+type Log struct {
+	mutex sync.Mutex
+	file  *os.File
+	key   []byte
+	seq   uint64
+	last  string
+}
+
+// Open opens the audit log at path for appending, creating it when missing, and
+// hashes new entries under key. The chain is continued from the last entry, so
+// Open fails when the last line is not an entry; the log has to be inspected
+// with Verify then.
+func Open(path string, key []byte) (*Log, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{file: file, key: key, last: Genesis}
+	last, err := lastLine(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if last != nil {
+		var entry Entry
+		err = json.Unmarshal(last, &entry)
+		if err != nil || entry.Hash == "" {
+			file.Close()
+			return nil, fmt.Errorf("last line of audit log %s is not an audit entry", path)
+		}
+		l.seq = entry.Seq
+		l.last = entry.Hash
+	}
+	return l, nil
+}
+
+func lastLine(file *os.File) ([]byte, error) {
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	return last, scanner.Err()
+}
+
+const maxLineSize = 1 << 20
+
+// Record numbers entry, chains it to the previous one and appends it. The
+// entry is synced to disk before Record returns.
+func (l *Log) Record(entry Entry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return errors.New("audit log is closed")
+	}
+
+	entry.Seq = l.seq + 1
+	entry.Prev = l.last
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	hash, err := entry.hash(l.key)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+	l.seq = entry.Seq
+	l.last = entry.Hash
+	return nil
+}
+
+// Head returns the sequence number and hash of the last entry.
+func (l *Log) Head() (uint64, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.seq, l.last
+}
+
+// AnchorEvery writes the head of the chain to log every interval in which
+// entries were recorded, until stop is closed. A last entry below the last head
+// logged means entries were cut off the end, as long as the gateway log is kept
+// where the audit log cannot be written from.
+func (l *Log) AnchorEvery(interval time.Duration, stop <-chan struct{}, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var anchored uint64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			seq, hash := l.Head()
+			if seq != anchored {
+				log.Info("Audit log at entry " + strconv.FormatUint(seq, 10) + " with hash " + hash)
+				anchored = seq
+			}
+		}
+	}
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Break is a place where the chain does not hold.
+type Break struct {
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (b Break) String() string {
+	return fmt.Sprintf("line %d (seq %d): %s", b.Line, b.Seq, b.Reason)
+}
+
+// Report is the result of verifying an audit log. Seq and Hash are those of the
+// last entry.
+type Report struct {
+	Entries int
+	Seq     uint64
+	Hash    string
+	Breaks  []Break
+}
+
+// Verify walks the chain of the audit log read from r, hashed under key, and
+// reports every entry that was edited, removed or inserted. After a break
+// verification continues from the entry found there, so each tampered place is
+// reported once.
+func Verify(r io.Reader, key []byte) (*Report, error) {
+	report := &Report{Hash: Genesis}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var entry Entry
+		err := json.Unmarshal(raw, &entry)
+		if err != nil {
+			// The line is taken to replace the next entry, whose hash is
+			// unknown, so the entry after it is not reported again.
+			report.Seq++
+			report.Hash = ""
+			report.Breaks = append(report.Breaks, Break{Line: line, Seq: report.Seq, Reason: "not an audit entry"})
+			continue
+		}
+		report.Entries++
+
+		if entry.Seq != report.Seq+1 {
+			report.Breaks = append(report.Breaks, Break{Line: line, Seq: entry.Seq, Reason: fmt.Sprintf("expected seq %d", report.Seq+1)})
+		} else if report.Hash != "" && entry.Prev != report.Hash {
+			report.Breaks = append(report.Breaks, Break{Line: line, Seq: entry.Seq, Reason: "previous hash does not match the entry before"})
+		}
+		hash, err := entry.hash(key)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(hash), []byte(entry.Hash)) {
+			report.Breaks = append(report.Breaks, Break{Line: line, Seq: entry.Seq, Reason: "entry was modified"})
+		} else if canonical, err := json.Marshal(entry); err != nil || !bytes.Equal(canonical, raw) {
+			report.Breaks = append(report.Breaks, Break{Line: line, Seq: entry.Seq, Reason: "entry holds data outside of its hash"})
+		}
+		report.Seq = entry.Seq
+		report.Hash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
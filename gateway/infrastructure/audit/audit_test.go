@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("audit key")
+
+// record writes entries with the given actions to a new audit log and returns
+// its lines.
+func record(t *testing.T, actions ...string) []string {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range actions {
+		err = l.Record(Entry{Action: action, Method: "/user.UserService/" + action, ActorId: "1", Outcome: OutcomeSuccess, Code: "OK"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func verify(t *testing.T, lines []string, key []byte) *Report {
+	report, err := Verify(strings.NewReader(strings.Join(lines, "\n")+"\n"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// edit decodes the entry on line, applies change and encodes it again without
+// fixing its hash.
+func edit(t *testing.T, line string, change func(entry *Entry)) string {
+	var entry Entry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	change(&entry)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRecordChainsEntries(t *testing.T) {
+	lines := record(t, "login", "change-password", "delete-user")
+	prev := Genesis
+	for n, line := range lines {
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Seq != uint64(n+1) || entry.Prev != prev || entry.Time.IsZero() {
+			t.Errorf("entry %d is %+v, want seq %d after %s", n, entry, n+1, prev)
+		}
+		prev = entry.Hash
+	}
+
+	report := verify(t, lines, testKey)
+	if len(report.Breaks) != 0 || report.Entries != 3 || report.Seq != 3 || report.Hash != prev {
+		t.Errorf("got %+v, want an intact chain of 3 entries", report)
+	}
+}
+
+func TestOpenContinuesTheChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for n := 0; n < 2; n++ {
+		l, err := Open(path, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Record(Entry{Action: "login", Outcome: OutcomeSuccess, Code: "OK"}); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	report, err := Verify(file, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Breaks) != 0 || report.Seq != 2 {
+		t.Errorf("got %+v, want an intact chain of 2 entries", report)
+	}
+}
+
+func TestOpenRejectsAGarbledLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("{\"seq\":1,\"act\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, testKey); err == nil {
+		t.Error("opened an audit log ending in a garbled line")
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		key    []byte
+		seqs   []uint64
+	}{
+		{"edited entry", func(lines []string) []string {
+			lines[1] = edit(t, lines[1], func(entry *Entry) { entry.ActorId = "2" })
+			return lines
+		}, testKey, []uint64{2}},
+		{"edited entry with its hash recomputed without the key", func(lines []string) []string {
+			lines[1] = edit(t, lines[1], func(entry *Entry) {
+				entry.Outcome = OutcomeFailure
+				entry.Hash = ""
+				data, _ := json.Marshal(entry)
+				sum := sha256.Sum256(data)
+				entry.Hash = hex.EncodeToString(sum[:])
+			})
+			return lines
+		}, testKey, []uint64{2, 3}},
+		{"deleted entry", func(lines []string) []string {
+			return append(lines[:1:1], lines[2:]...)
+		}, testKey, []uint64{3}},
+		{"reordered entries", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, testKey, []uint64{3, 2, 4}},
+		{"inserted entry", func(lines []string) []string {
+			return append(lines[:2:2], append([]string{lines[1]}, lines[2:]...)...)
+		}, testKey, []uint64{2}},
+		{"data outside of the hash", func(lines []string) []string {
+			lines[0] = strings.TrimSuffix(lines[0], "}") + `,"note":"x"}`
+			return lines
+		}, testKey, []uint64{1}},
+		{"garbled line", func(lines []string) []string {
+			lines[1] = lines[1][:20]
+			return lines
+		}, testKey, []uint64{2}},
+		{"other key", func(lines []string) []string {
+			return lines
+		}, []byte("other key"), []uint64{1, 2, 3, 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := test.tamper(record(t, "login", "change-password", "change-username", "delete-user"))
+			report := verify(t, lines, test.key)
+			var seqs []uint64
+			for _, b := range report.Breaks {
+				seqs = append(seqs, b.Seq)
+			}
+			if !equalSeqs(seqs, test.seqs) {
+				t.Errorf("breaks at %v, want %v: %v", seqs, test.seqs, report.Breaks)
+			}
+		})
+	}
+}
+
+func TestVerifyCannotDetectTruncation(t *testing.T) {
+	lines := record(t, "login", "change-password", "delete-user")
+	report := verify(t, lines[:2], testKey)
+	// Only the head written to the gateway log shows that entry 3 is missing.
+	if len(report.Breaks) != 0 || report.Seq != 2 {
+		t.Errorf("got %+v", report)
+	}
+}
+
+func TestAnchorEveryLogsNewHeads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Record(Entry{Action: "login", Outcome: OutcomeSuccess, Code: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		l.AnchorEvery(5*time.Millisecond, stop, logger)
+		close(done)
+	}()
+	time.Sleep(40 * time.Millisecond)
+	close(stop)
+	<-done
+
+	_, hash := l.Head()
+	if got := strings.Count(out.String(), "Audit log at entry 1 with hash "+hash); got != 1 {
+		t.Errorf("head was logged %d times, want once while unchanged:\n%s", got, out.String())
+	}
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package authcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+// Kinds of credentials whose verification results are cached.
+const (
+	KindJwt      = "jwt"
+	KindApiToken = "api_token"
+)
+
+// Entry is the result of a successful credential verification.
+type Entry struct {
+	UserId string
+	Role   string
+}
+
+type item struct {
+	key       string
+	entry     Entry
+	expiresAt time.Time
+}
+
+// Cache is a size bounded LRU cache of verification results keyed by the hash
+// of the credential, so raw tokens are never kept in memory longer than the
+// request that carried them. Results are remembered for at most the configured
+// TTL and never past the expiry of the credential itself.
+type Cache struct {
+	capacity int
+	ttl      time.Duration
+	mu       sync.Mutex
+	order    *list.List
+	items    map[string]*list.Element
+	byUser   map[string]map[string]struct{}
+	// generation changes on every invalidation, so results loaded while an
+	// invalidation happened are not stored.
+	generation uint64
+	group      singleflight.Group
+}
+
+// NewCache creates a cache holding up to capacity results. A capacity or TTL of
+// zero disables caching; every lookup is then loaded.
+func NewCache(capacity int, ttl time.Duration) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+		byUser:   map[string]map[string]struct{}{},
+	}
+}
+
+// Load returns the cached result for the credential or calls load to verify
+// it. Concurrent loads of the same credential share a single call. Failed
+// verifications are not cached; expiresAt bounds how long a result is kept and
+// may be zero when the credential does not expire.
+func (c *Cache) Load(kind string, credential string, load func() (Entry, time.Time, error)) (Entry, error) {
+	key := kind + ":" + hash(credential)
+	if entry, ok := c.get(key); ok {
+		return entry, nil
+	}
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		generation := c.currentGeneration()
+		entry, expiresAt, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.put(key, entry, expiresAt, generation)
+		return entry, nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	return value.(Entry), nil
+}
+
+// InvalidateCredential forgets the cached result for a single credential.
+func (c *Cache) InvalidateCredential(kind string, credential string) {
+	key := kind + ":" + hash(credential)
+	c.group.Forget(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// InvalidateUser forgets every cached result that belongs to userId, e.g. after
+// the user changed their password or removed their API token.
+func (c *Cache) InvalidateUser(userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key := range c.byUser[userId] {
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *Cache) get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	cached := element.Value.(*item)
+	if time.Now().After(cached.expiresAt) {
+		c.remove(element)
+		return Entry{}, false
+	}
+	c.order.MoveToFront(element)
+	return cached.entry, true
+}
+
+func (c *Cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *Cache) put(key string, entry Entry, expiresAt time.Time, generation uint64) {
+	if c.capacity <= 0 || c.ttl <= 0 {
+		return
+	}
+	limit := time.Now().Add(c.ttl)
+	if expiresAt.IsZero() || expiresAt.After(limit) {
+		expiresAt = limit
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+	c.items[key] = c.order.PushFront(&item{key: key, entry: entry, expiresAt: expiresAt})
+	if c.byUser[entry.UserId] == nil {
+		c.byUser[entry.UserId] = map[string]struct{}{}
+	}
+	c.byUser[entry.UserId][key] = struct{}{}
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove must be called with mu held.
+func (c *Cache) remove(element *list.Element) {
+	cached := c.order.Remove(element).(*item)
+	delete(c.items, cached.key)
+	keys := c.byUser[cached.entry.UserId]
+	delete(keys, cached.key)
+	if len(keys) == 0 {
+		delete(c.byUser, cached.entry.UserId)
+	}
+}
+
+func hash(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,219 @@
+package authcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loader returns a load function for entry that counts its calls.
+func loader(entry Entry, expiresAt time.Time, calls *int32) func() (Entry, time.Time, error) {
+	return func() (Entry, time.Time, error) {
+		atomic.AddInt32(calls, 1)
+		return entry, expiresAt, nil
+	}
+}
+
+func TestLoadCachesResults(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	for n := 0; n < 3; n++ {
+		entry, err := cache.Load(KindJwt, "token", loader(Entry{UserId: "1", Role: "USER"}, time.Time{}, &calls))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.UserId != "1" || entry.Role != "USER" {
+			t.Fatalf("got %+v", entry)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loaded %d times, want 1", calls)
+	}
+}
+
+func TestLoadDoesNotCacheFailures(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	failing := func() (Entry, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		return Entry{}, time.Time{}, errors.New("invalid token")
+	}
+	for n := 0; n < 2; n++ {
+		if _, err := cache.Load(KindJwt, "token", failing); err == nil {
+			t.Fatal("expected the error of load")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("loaded %d times, want 2", calls)
+	}
+}
+
+func TestLoadKeepsKindsApart(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	cache.Load(KindJwt, "secret", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	entry, _ := cache.Load(KindApiToken, "secret", loader(Entry{UserId: "2"}, time.Time{}, &calls))
+	if entry.UserId != "2" || calls != 2 {
+		t.Errorf("got %+v after %d loads, want user 2 after 2 loads", entry, calls)
+	}
+}
+
+func TestLeastRecentlyUsedIsEvicted(t *testing.T) {
+	cache := NewCache(2, time.Minute)
+	var calls int32
+	cache.Load(KindJwt, "a", loader(Entry{UserId: "a"}, time.Time{}, &calls))
+	cache.Load(KindJwt, "b", loader(Entry{UserId: "b"}, time.Time{}, &calls))
+	// Using a makes b the least recently used entry.
+	cache.Load(KindJwt, "a", loader(Entry{UserId: "a"}, time.Time{}, &calls))
+	cache.Load(KindJwt, "c", loader(Entry{UserId: "c"}, time.Time{}, &calls))
+	if calls != 3 {
+		t.Fatalf("loaded %d times, want 3", calls)
+	}
+
+	tests := []struct {
+		credential string
+		cached     bool
+	}{
+		{"a", true},
+		{"c", true},
+		// Last, since loading b evicts another entry.
+		{"b", false},
+	}
+	for _, test := range tests {
+		calls = 0
+		cache.Load(KindJwt, test.credential, loader(Entry{UserId: test.credential}, time.Time{}, &calls))
+		if cached := calls == 0; cached != test.cached {
+			t.Errorf("%s cached = %v, want %v", test.credential, cached, test.cached)
+		}
+		if cache.order.Len() > 2 {
+			t.Fatalf("cache holds %d entries, capacity is 2", cache.order.Len())
+		}
+	}
+}
+
+func TestEntriesExpire(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		expiresAt time.Time
+		wait      time.Duration
+		cached    bool
+	}{
+		{"within ttl", time.Minute, time.Time{}, 0, true},
+		{"after ttl", 20 * time.Millisecond, time.Time{}, 40 * time.Millisecond, false},
+		{"after credential expiry", time.Minute, time.Now().Add(20 * time.Millisecond), 40 * time.Millisecond, false},
+		{"before credential expiry", time.Minute, time.Now().Add(time.Minute), 0, true},
+		{"zero ttl", 0, time.Time{}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewCache(10, test.ttl)
+			var calls int32
+			cache.Load(KindJwt, "token", loader(Entry{UserId: "1"}, test.expiresAt, &calls))
+			time.Sleep(test.wait)
+			cache.Load(KindJwt, "token", loader(Entry{UserId: "1"}, test.expiresAt, &calls))
+			if cached := calls == 1; cached != test.cached {
+				t.Errorf("cached = %v, want %v", cached, test.cached)
+			}
+		})
+	}
+}
+
+func TestInvalidateUser(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	cache.Load(KindJwt, "jwt of 1", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	cache.Load(KindApiToken, "api token of 1", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	cache.Load(KindJwt, "jwt of 2", loader(Entry{UserId: "2"}, time.Time{}, &calls))
+
+	cache.InvalidateUser("1")
+
+	calls = 0
+	cache.Load(KindJwt, "jwt of 1", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	cache.Load(KindApiToken, "api token of 1", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	if calls != 2 {
+		t.Errorf("reloaded %d credentials of user 1, want 2", calls)
+	}
+	calls = 0
+	cache.Load(KindJwt, "jwt of 2", loader(Entry{UserId: "2"}, time.Time{}, &calls))
+	if calls != 0 {
+		t.Error("credential of user 2 was invalidated")
+	}
+}
+
+func TestInvalidateCredential(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	cache.Load(KindJwt, "a", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	cache.Load(KindJwt, "b", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+
+	cache.InvalidateCredential(KindJwt, "a")
+
+	calls = 0
+	cache.Load(KindJwt, "a", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	cache.Load(KindJwt, "b", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	if calls != 1 {
+		t.Errorf("reloaded %d credentials, want only a", calls)
+	}
+}
+
+func TestInvalidationDuringLoadIsNotOverwritten(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	// The user changes their password while their token is being verified,
+	// so the result of that verification must not be kept.
+	cache.Load(KindJwt, "token", func() (Entry, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		cache.InvalidateUser("1")
+		return Entry{UserId: "1"}, time.Time{}, nil
+	})
+	cache.Load(KindJwt, "token", loader(Entry{UserId: "1"}, time.Time{}, &calls))
+	if calls != 2 {
+		t.Errorf("loaded %d times, want 2", calls)
+	}
+}
+
+func TestConcurrentMissesShareOneLoad(t *testing.T) {
+	cache := NewCache(10, time.Minute)
+	var calls int32
+	release := make(chan struct{})
+	load := func() (Entry, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return Entry{UserId: "1"}, time.Time{}, nil
+	}
+
+	const callers = 20
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	results := make(chan Entry, callers)
+	for n := 0; n < callers; n++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			entry, err := cache.Load(KindJwt, "token", load)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- entry
+		}()
+	}
+	started.Wait()
+	// Give every caller time to join the load in flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Errorf("loaded %d times, want 1", calls)
+	}
+	for entry := range results {
+		if entry.UserId != "1" {
+			t.Errorf("got %+v", entry)
+		}
+	}
+}
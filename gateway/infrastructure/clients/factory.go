@@ -0,0 +1,169 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"gateway/infrastructure/metrics"
+	"gateway/infrastructure/retry"
+	"gateway/startup/config"
+	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
+	jobService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/job"
+	messageService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/message"
+	postService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/post"
+	userService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/user"
+	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
+	otgo "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"sync"
+)
+
+// Factory creates the clients of the backend services. Clients of the same
+// address share a connection, and every connection uses the message size
+// limits of the configuration.
+type Factory struct {
+	config   *config.Config
+	options  []grpc.DialOption
+	mutex    sync.Mutex
+	conns    map[string]*grpc.ClientConn
+	backends map[string]*grpc.ClientConn
+}
+
+func NewFactory(c *config.Config) *Factory {
+	return &Factory{
+		config: c,
+		options: []grpc.DialOption{
+			grpc.WithInsecure(),
+			// Responses of the backends are passed on to the callers, so
+			// they are limited like the responses of the gateway.
+			grpc.WithDefaultCallOptions(
+				grpc.MaxCallRecvMsgSize(int(c.GrpcMaxSendMsgSize)),
+				grpc.MaxCallSendMsgSize(int(c.GrpcMaxSendMsgSize)),
+			),
+			grpc.WithStreamInterceptor(
+				grpc_opentracing.StreamClientInterceptor(
+					grpc_opentracing.WithTracer(otgo.GlobalTracer()),
+				),
+			),
+		},
+		conns:    map[string]*grpc.ClientConn{},
+		backends: map[string]*grpc.ClientConn{},
+	}
+}
+
+func (f *Factory) User() userService.UserServiceClient {
+	return userService.NewUserServiceClient(f.conn("user", f.config.UserServiceHost, f.config.UserServicePort))
+}
+
+func (f *Factory) Post() postService.PostServiceClient {
+	return postService.NewPostServiceClient(f.conn("post", f.config.PostServiceHost, f.config.PostServicePort))
+}
+
+func (f *Factory) Connection() connectionService.ConnectionServiceClient {
+	return connectionService.NewConnectionServiceClient(f.conn("connection", f.config.ConnectionServiceHost, f.config.ConnectionServicePort))
+}
+
+func (f *Factory) Job() jobService.JobServiceClient {
+	return jobService.NewJobServiceClient(f.conn("job", f.config.JobServiceHost, f.config.JobServicePort))
+}
+
+func (f *Factory) Message() messageService.MessageServiceClient {
+	return messageService.NewMessageServiceClient(f.conn("message", f.config.MessageServiceHost, f.config.MessageServicePort))
+}
+
+// Backends returns the connection of every backend a client was created for
+// by backend name, e.g. "user".
+func (f *Factory) Backends() map[string]*grpc.ClientConn {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	backends := make(map[string]*grpc.ClientConn, len(f.backends))
+	for name, conn := range f.backends {
+		backends[name] = conn
+	}
+	return backends
+}
+
+func (f *Factory) conn(backend string, host string, port string) *grpc.ClientConn {
+	address := fmt.Sprintf("%s:%s", host, port)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if conn, ok := f.conns[address]; ok {
+		f.backends[backend] = conn
+		return conn
+	}
+	// Dialing does not block, so this only fails on invalid options.
+	conn, err := grpc.Dial(address, append(f.options, grpc.WithChainUnaryInterceptor(
+		grpc_opentracing.UnaryClientInterceptor(
+			grpc_opentracing.WithTracer(otgo.GlobalTracer()),
+		),
+		tagBackend(backend),
+		retry.UnaryClientInterceptor(backend, f.retryPolicy(backend)),
+		metrics.UnaryClientInterceptor(backend),
+		forwardRequestId,
+	))...)
+	if err != nil {
+		log.Fatalln("Failed to start client for "+address+":", err)
+	}
+	f.conns[address] = conn
+	f.backends[backend] = conn
+	return conn
+}
+
+// retryPolicy returns how calls to backend are retried. Attempts set for the
+// backend in RETRY_ATTEMPTS replace RETRY_MAX_ATTEMPTS, and every backend has a
+// budget of its own.
+func (f *Factory) retryPolicy(backend string) retry.Policy {
+	attempts, ok := f.config.RetryAttempts[backend]
+	if !ok {
+		attempts = f.config.RetryMaxAttempts
+	}
+	return retry.Policy{
+		MaxAttempts: attempts,
+		Backoff:     f.config.RetryBackoff,
+		MaxBackoff:  f.config.RetryMaxBackoff,
+		Budget:      retry.NewBudget(f.config.RetryBudgetRatio, f.config.RetryBudgetReserve),
+	}
+}
+
+// tagBackend names the backend on the span of the call, so traces show which
+// service a slow call went to.
+func tagBackend(backend string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if span := otgo.SpanFromContext(ctx); span != nil {
+			ext.PeerService.Set(span, backend)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// requestIdMetadata is the metadata key of the request id, see
+// api.RequestIdMetadata.
+const requestIdMetadata = "x-request-id"
+
+// forwardRequestId passes the request id of the call being served on to the
+// backend so that their logs can be correlated.
+func forwardRequestId(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIdMetadata); len(values) > 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, requestIdMetadata, values[0])
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Close closes the connections of every client created so far.
+func (f *Factory) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var firstErr error
+	for address, conn := range f.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(f.conns, address)
+	}
+	f.backends = map[string]*grpc.ClientConn{}
+	return firstErr
+}
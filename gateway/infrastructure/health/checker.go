@@ -0,0 +1,162 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Checker tells whether the gateway can serve requests. The gateway is ready
+// while it is not shutting down and the connections to all backends that are
+// not optional are established.
+type Checker struct {
+	backends map[string]*grpc.ClientConn
+	optional map[string]bool
+	serving  func() bool
+	server   *health.Server
+}
+
+// Report is the readiness of the gateway and the state of each backend.
+type Report struct {
+	Ready    bool                     `json:"ready"`
+	Backends map[string]BackendReport `json:"backends,omitempty"`
+}
+
+type BackendReport struct {
+	State    string `json:"state"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// NewChecker checks backends by name. serving reports whether the gateway
+// itself is serving.
+func NewChecker(backends map[string]*grpc.ClientConn, optional []string, serving func() bool) *Checker {
+	checker := &Checker{
+		backends: backends,
+		optional: map[string]bool{},
+		serving:  serving,
+		server:   health.NewServer(),
+	}
+	for _, name := range optional {
+		checker.optional[name] = true
+	}
+	checker.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return checker
+}
+
+// UnknownBackends returns the optional backends that the gateway has no
+// connection to, which usually means a typo in the configuration.
+func (c *Checker) UnknownBackends() []string {
+	var unknown []string
+	for name := range c.optional {
+		if _, ok := c.backends[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Check reports the readiness of the gateway. Idle backend connections are
+// asked to connect so that a later check can find them ready.
+func (c *Checker) Check() Report {
+	report := Report{
+		Ready:    c.serving(),
+		Backends: make(map[string]BackendReport, len(c.backends)),
+	}
+	for name, conn := range c.backends {
+		state := conn.GetState()
+		if state == connectivity.Idle {
+			conn.Connect()
+		}
+		report.Backends[name] = BackendReport{State: state.String(), Optional: c.optional[name]}
+		if state != connectivity.Ready && !c.optional[name] {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// Server is the grpc.health.v1 service of the gateway. The status of the
+// empty service name follows readiness once Watch runs.
+func (c *Checker) Server() healthpb.HealthServer {
+	return c.server
+}
+
+// Watch updates the status of the gRPC health service every interval until
+// stop is closed.
+func (c *Checker) Watch(interval time.Duration, stop <-chan struct{}, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ready := false
+	for {
+		report := c.Check()
+		if report.Ready != ready {
+			ready = report.Ready
+			if ready {
+				log.Info("Gateway is ready")
+				c.server.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			} else {
+				log.Warn("Gateway is not ready: " + c.notReady(report))
+				c.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) notReady(report Report) string {
+	if !c.serving() {
+		return "shutting down"
+	}
+	var down []string
+	for name, backend := range report.Backends {
+		if backend.State != connectivity.Ready.String() && !backend.Optional {
+			down = append(down, name+" is "+backend.State)
+		}
+	}
+	sort.Strings(down)
+	return strings.Join(down, ", ")
+}
+
+// Shutdown reports every service of the gRPC health service as not serving
+// from now on.
+func (c *Checker) Shutdown() {
+	c.server.Shutdown()
+}
+
+// Liveness answers /healthz. The gateway is alive as long as it answers.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness answers /readyz with 200 when the gateway is ready and 503
+// otherwise. The state of each backend is included with ?verbose.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	report := c.Check()
+	if _, verbose := r.URL.Query()["verbose"]; !verbose {
+		report.Backends = nil
+	}
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJson(w, code, report)
+}
+
+func writeJson(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
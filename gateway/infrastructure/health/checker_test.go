@@ -0,0 +1,142 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// backend is a running gRPC server and a connection to it.
+type backend struct {
+	server *grpc.Server
+	conn   *grpc.ClientConn
+}
+
+func startBackend(t *testing.T) *backend {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(listener)
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return &backend{server: server, conn: conn}
+}
+
+// eventually reports whether condition held within a second.
+func eventually(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+// readiness returns the status code and body of /readyz?verbose.
+func readiness(t *testing.T, checker *Checker) (int, Report) {
+	w := httptest.NewRecorder()
+	checker.Readiness(w, httptest.NewRequest(http.MethodGet, "/readyz?verbose", nil), nil)
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, report
+}
+
+func TestReadinessFollowsBackends(t *testing.T) {
+	user, post := startBackend(t), startBackend(t)
+	checker := NewChecker(map[string]*grpc.ClientConn{"user": user.conn, "post": post.conn}, nil, func() bool { return true })
+
+	if !eventually(func() bool { return checker.Check().Ready }) {
+		t.Fatalf("not ready with every backend up: %+v", checker.Check())
+	}
+	if code, report := readiness(t, checker); code != http.StatusOK || !report.Ready {
+		t.Errorf("got %d %+v with every backend up", code, report)
+	}
+
+	post.server.Stop()
+	if !eventually(func() bool { return !checker.Check().Ready }) {
+		t.Fatalf("still ready with a backend down: %+v", checker.Check())
+	}
+	code, report := readiness(t, checker)
+	if code != http.StatusServiceUnavailable || report.Ready {
+		t.Errorf("got %d %+v with a backend down", code, report)
+	}
+	if report.Backends["post"].State == "READY" || report.Backends["user"].State != "READY" {
+		t.Errorf("got backends %+v", report.Backends)
+	}
+}
+
+func TestReadinessIgnoresOptionalBackends(t *testing.T) {
+	user, message := startBackend(t), startBackend(t)
+	checker := NewChecker(map[string]*grpc.ClientConn{"user": user.conn, "message": message.conn}, []string{"message"}, func() bool { return true })
+	message.server.Stop()
+
+	if !eventually(func() bool { return checker.Check().Ready }) {
+		t.Fatalf("not ready with an optional backend down: %+v", checker.Check())
+	}
+	if _, report := readiness(t, checker); !report.Backends["message"].Optional {
+		t.Errorf("optional backend not reported as optional: %+v", report.Backends)
+	}
+}
+
+func TestReadinessEndsWhenShuttingDown(t *testing.T) {
+	serving := true
+	checker := NewChecker(nil, nil, func() bool { return serving })
+	if code, _ := readiness(t, checker); code != http.StatusOK {
+		t.Errorf("got %d while serving", code)
+	}
+	serving = false
+	if code, _ := readiness(t, checker); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d while shutting down", code)
+	}
+}
+
+func TestWatchUpdatesTheHealthService(t *testing.T) {
+	post := startBackend(t)
+	checker := NewChecker(map[string]*grpc.ClientConn{"post": post.conn}, nil, func() bool { return true })
+	log := logrus.New()
+	log.Out = io.Discard
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		checker.Watch(5*time.Millisecond, stop, log)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := checker.Server().Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	if !eventually(func() bool { return status() == healthpb.HealthCheckResponse_SERVING }) {
+		t.Fatalf("health service is %v with the backend up", status())
+	}
+	post.server.Stop()
+	if !eventually(func() bool { return status() == healthpb.HealthCheckResponse_NOT_SERVING }) {
+		t.Fatalf("health service is %v with the backend down", status())
+	}
+}
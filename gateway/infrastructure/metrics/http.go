@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// otherRoute labels requests that were not routed to a gRPC method, such as
+// health checks and requests for unknown paths.
+const otherRoute = "other"
+
+type routeKey struct{}
+
+type route struct {
+	pattern string
+}
+
+// HttpHandler measures every HTTP request by the route it was served by, as
+// recorded by AnnotateRoute, so that path parameters don't create a time
+// series each.
+func HttpHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HttpRequestsInFlight.Inc()
+		defer HttpRequestsInFlight.Dec()
+		start := time.Now()
+		matched := &route{pattern: otherRoute}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), routeKey{}, matched)))
+		HttpRequestDuration.WithLabelValues(matched.pattern, r.Method).Observe(time.Since(start).Seconds())
+		HttpRequests.WithLabelValues(matched.pattern, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+// AnnotateRoute is a grpc-gateway metadata annotator recording the path
+// pattern of the route, or the gRPC method when the route has none, for
+// HttpHandler.
+func AnnotateRoute(ctx context.Context, r *http.Request) metadata.MD {
+	matched, ok := ctx.Value(routeKey{}).(*route)
+	if !ok {
+		return nil
+	}
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		matched.pattern = pattern
+	} else if method, ok := runtime.RPCMethod(ctx); ok {
+		matched.pattern = method
+	}
+	return nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
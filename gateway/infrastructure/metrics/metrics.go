@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+const namespace = "gateway"
+
+// Registry holds every metric of the gateway together with the Go runtime and
+// process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	GrpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls served, by method and status code.",
+	}, []string{"method", "code"})
+	GrpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Time to serve gRPC calls, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	GrpcRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_in_flight",
+		Help:      "gRPC calls being served, by method.",
+	}, []string{"method"})
+
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route, HTTP method and status code.",
+	}, []string{"route", "http_method", "status"})
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by route and HTTP method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "http_method"})
+	HttpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Calls rejected by the access policy, by reason.",
+	}, []string{"reason"})
+	SanitizeRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sanitize_rejections_total",
+		Help:      "Calls rejected as possibly malicious, by method and detector.",
+	}, []string{"method", "detector"})
+	ContentRewrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "content_rewrites_total",
+		Help:      "User generated content fields rewritten, by method and mode.",
+	}, []string{"method", "mode"})
+	RateLimitHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_hits_total",
+		Help:      "Calls rejected by rate limits, lockouts and API token quotas, by method and limit: ip, user, lockout, quota or the limited request field.",
+	}, []string{"method", "limit"})
+
+	BackendRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Time of calls to the backend services, by backend, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "method", "code"})
+	BackendRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_retries_total",
+		Help:      "Calls to the backend services retried, by backend, method and the status code of the failed attempt.",
+	}, []string{"backend", "method", "code"})
+	BackendRetriesDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_retries_denied_total",
+		Help:      "Retries of calls to the backend services not made because the retry budget was spent, by backend.",
+	}, []string{"backend"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		GrpcRequests, GrpcRequestDuration, GrpcRequestsInFlight,
+		HttpRequests, HttpRequestDuration, HttpRequestsInFlight,
+		AuthFailures, SanitizeRejections, ContentRewrites, RateLimitHits,
+		BackendRequestDuration, BackendRetries, BackendRetriesDenied,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// UnaryServerInterceptor measures every gRPC call served. It must run first,
+// before the recovery interceptor, so that calls rejected by other interceptors
+// are counted too and calls that panicked are counted as Internal.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		inFlight := GrpcRequestsInFlight.WithLabelValues(info.FullMethod)
+		inFlight.Inc()
+		defer inFlight.Dec()
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		GrpcRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		GrpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor measures the calls to backend.
+func UnaryClientInterceptor(backend string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		BackendRequestDuration.WithLabelValues(backend, method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
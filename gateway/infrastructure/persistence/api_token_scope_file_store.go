@@ -0,0 +1,47 @@
+package persistence
+
+import (
+	"gateway/domain"
+	"sync"
+)
+
+// ApiTokenScopeFileStore keeps API token scopes in memory and writes them
+// through to a JSON file, so they survive restarts of a single gateway.
+type ApiTokenScopeFileStore struct {
+	path   string
+	mu     sync.RWMutex
+	scopes map[string][]string
+}
+
+func NewApiTokenScopeFileStore(path string) (domain.ApiTokenScopeStore, error) {
+	store := &ApiTokenScopeFileStore{path: path, scopes: map[string][]string{}}
+	err := readJsonFile(path, &store.scopes)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *ApiTokenScopeFileStore) Get(userId string) ([]string, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	scopes, found := store.scopes[userId]
+	return scopes, found, nil
+}
+
+func (store *ApiTokenScopeFileStore) Save(userId string, scopes []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.scopes[userId] = scopes
+	return writeJsonFile(store.path, store.scopes)
+}
+
+func (store *ApiTokenScopeFileStore) Delete(userId string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, found := store.scopes[userId]; !found {
+		return nil
+	}
+	delete(store.scopes, userId)
+	return writeJsonFile(store.path, store.scopes)
+}
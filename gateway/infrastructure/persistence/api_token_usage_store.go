@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"gateway/domain"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ApiTokenUsageStore keeps API token usage in memory. The file-backed variant
+// writes the counters to a JSON file every flush interval instead of on every
+// call, so a crash loses at most one interval of usage.
+type ApiTokenUsageStore struct {
+	path  string
+	mu    sync.Mutex
+	usage map[string]*domain.ApiTokenUsage
+	dirty bool
+}
+
+// NewApiTokenUsageMemoryStore creates a store whose counters are lost when the
+// gateway stops.
+func NewApiTokenUsageMemoryStore() *ApiTokenUsageStore {
+	return &ApiTokenUsageStore{usage: map[string]*domain.ApiTokenUsage{}}
+}
+
+// NewApiTokenUsageFileStore creates a store that loads its counters from path
+// and writes them back on Flush.
+func NewApiTokenUsageFileStore(path string) (*ApiTokenUsageStore, error) {
+	store := &ApiTokenUsageStore{path: path, usage: map[string]*domain.ApiTokenUsage{}}
+	err := readJsonFile(path, &store.usage)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *ApiTokenUsageStore) Take(userId string, at time.Time, quota domain.ApiTokenQuota) (domain.ApiTokenUsage, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	usage, ok := store.usage[userId]
+	if !ok {
+		usage = &domain.ApiTokenUsage{}
+		store.usage[userId] = usage
+	}
+	taken := usage.Take(at, quota)
+	if taken {
+		store.dirty = true
+	}
+	return *usage, taken, nil
+}
+
+func (store *ApiTokenUsageStore) Get(userId string) (domain.ApiTokenUsage, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	usage, ok := store.usage[userId]
+	if !ok {
+		return domain.ApiTokenUsage{}, false, nil
+	}
+	current := *usage
+	current.Roll(time.Now())
+	return current, true, nil
+}
+
+func (store *ApiTokenUsageStore) GetAll() (map[string]domain.ApiTokenUsage, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	all := make(map[string]domain.ApiTokenUsage, len(store.usage))
+	for userId, usage := range store.usage {
+		current := *usage
+		current.Roll(now)
+		all[userId] = current
+	}
+	return all, nil
+}
+
+// Flush writes the counters to the file if they changed since the last flush.
+// It does nothing for stores kept in memory only.
+func (store *ApiTokenUsageStore) Flush() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.path == "" || !store.dirty {
+		return nil
+	}
+	err := writeJsonFile(store.path, store.usage)
+	if err != nil {
+		return err
+	}
+	store.dirty = false
+	return nil
+}
+
+// FlushEvery flushes the counters every interval and a last time when stop is
+// closed.
+func (store *ApiTokenUsageStore) FlushEvery(interval time.Duration, stop <-chan struct{}, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			err := store.Flush()
+			if err != nil {
+				log.Warn("Failed to save API token usage: " + err.Error())
+			}
+			return
+		case <-ticker.C:
+			err := store.Flush()
+			if err != nil {
+				log.Warn("Failed to save API token usage: " + err.Error())
+			}
+		}
+	}
+}
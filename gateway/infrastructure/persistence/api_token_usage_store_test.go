@@ -0,0 +1,122 @@
+package persistence
+
+import (
+	"gateway/domain"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApiTokenUsageStoreRollsOverPeriods(t *testing.T) {
+	start := time.Date(2022, 5, 1, 23, 58, 30, 0, time.UTC)
+	quota := domain.ApiTokenQuota{PerMinute: 2, PerDay: 3}
+	tests := []struct {
+		at     time.Time
+		taken  bool
+		minute int64
+		day    int64
+	}{
+		{start, true, 1, 1},
+		{start.Add(10 * time.Second), true, 2, 2},
+		// The minute is used up.
+		{start.Add(20 * time.Second), false, 2, 2},
+		// A new minute starts, the day goes on.
+		{start.Add(40 * time.Second), true, 1, 3},
+		// The day is used up.
+		{start.Add(50 * time.Second), false, 1, 3},
+		// A new UTC day starts.
+		{start.Add(100 * time.Second), true, 1, 1},
+	}
+	store := NewApiTokenUsageMemoryStore()
+	for n, test := range tests {
+		usage, taken, err := store.Take("1", test.at, quota)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken != test.taken || usage.Minute != test.minute || usage.Day != test.day {
+			t.Errorf("call %d: taken %v with %d this minute and %d today, want %v with %d and %d",
+				n, taken, usage.Minute, usage.Day, test.taken, test.minute, test.day)
+		}
+	}
+	usage, _, _ := store.Get("1")
+	if usage.Total != 4 {
+		t.Errorf("total is %d, want the 4 calls taken", usage.Total)
+	}
+}
+
+func TestApiTokenUsageStoreGetRollsOver(t *testing.T) {
+	store := NewApiTokenUsageMemoryStore()
+	store.Take("1", time.Now().Add(-48*time.Hour), domain.ApiTokenQuota{})
+
+	usage, found, err := store.Get("1")
+	if err != nil || !found {
+		t.Fatalf("found %v, %v", found, err)
+	}
+	if usage.Minute != 0 || usage.Day != 0 || usage.Total != 1 {
+		t.Errorf("got %+v, want only the total left from two days ago", usage)
+	}
+	if _, found, _ := store.Get("2"); found {
+		t.Error("usage of unknown user found")
+	}
+}
+
+func TestApiTokenUsageStoreConcurrentTakes(t *testing.T) {
+	const callers, calls, perDay = 20, 50, 600
+	store := NewApiTokenUsageMemoryStore()
+	now := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for n := 0; n < callers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := 0; c < calls; c++ {
+				_, ok, err := store.Take("1", now, domain.ApiTokenQuota{PerDay: perDay})
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	usage, _, _ := store.Get("1")
+	if taken != perDay || usage.Day != perDay || usage.Total != perDay {
+		t.Errorf("took %d calls, counted %d today and %d in total, want exactly %d", taken, usage.Day, usage.Total, perDay)
+	}
+}
+
+func TestApiTokenUsageFileStoreFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store, err := NewApiTokenUsageFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Take("1", time.Now(), domain.ApiTokenQuota{})
+	store.Take("1", time.Now(), domain.ApiTokenQuota{})
+
+	reopened, _ := NewApiTokenUsageFileStore(path)
+	if _, found, _ := reopened.Get("1"); found {
+		t.Error("usage was written before flushing")
+	}
+
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewApiTokenUsageFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, found, _ := reopened.Get("1")
+	if !found || usage.Total != 2 || usage.Day != 2 {
+		t.Errorf("got %+v after reopening, want 2 calls", usage)
+	}
+}
@@ -0,0 +1,116 @@
+package persistence
+
+import (
+	"gateway/domain"
+	"sync"
+	"time"
+)
+
+type userRevocation struct {
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type revocations struct {
+	Tokens map[string]time.Time      `json:"tokens"`
+	Users  map[string]userRevocation `json:"users"`
+}
+
+// RevocationStore keeps the denylist in memory. Expired entries are dropped
+// whenever a new one is added.
+type RevocationStore struct {
+	mu      sync.RWMutex
+	entries revocations
+	// persist is called with mu held after every change.
+	persist func(entries *revocations) error
+}
+
+// NewRevocationMemoryStore creates a denylist that is lost when the gateway
+// stops.
+func NewRevocationMemoryStore() domain.RevocationStore {
+	return newRevocationStore()
+}
+
+// NewRevocationFileStore creates a denylist that is written through to a JSON
+// file, so logged out tokens stay denied after a restart of a single gateway.
+func NewRevocationFileStore(path string) (domain.RevocationStore, error) {
+	store := newRevocationStore()
+	err := readJsonFile(path, &store.entries)
+	if err != nil {
+		return nil, err
+	}
+	if store.entries.Tokens == nil {
+		store.entries.Tokens = map[string]time.Time{}
+	}
+	if store.entries.Users == nil {
+		store.entries.Users = map[string]userRevocation{}
+	}
+	store.persist = func(entries *revocations) error {
+		return writeJsonFile(path, entries)
+	}
+	return store, nil
+}
+
+func newRevocationStore() *RevocationStore {
+	return &RevocationStore{
+		entries: revocations{
+			Tokens: map[string]time.Time{},
+			Users:  map[string]userRevocation{},
+		},
+	}
+}
+
+func (store *RevocationStore) RevokeToken(tokenId string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.prune(time.Now())
+	store.entries.Tokens[tokenId] = expiresAt
+	return store.save()
+}
+
+func (store *RevocationStore) IsTokenRevoked(tokenId string) (bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	expiresAt, found := store.entries.Tokens[tokenId]
+	return found && time.Now().Before(expiresAt), nil
+}
+
+func (store *RevocationStore) RevokeUser(userId string, revokedAt time.Time, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.prune(time.Now())
+	store.entries.Users[userId] = userRevocation{RevokedAt: revokedAt.Truncate(time.Second), ExpiresAt: expiresAt}
+	return store.save()
+}
+
+func (store *RevocationStore) UserRevokedAt(userId string) (time.Time, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	revocation, found := store.entries.Users[userId]
+	if !found || !time.Now().Before(revocation.ExpiresAt) {
+		return time.Time{}, false, nil
+	}
+	return revocation.RevokedAt, true, nil
+}
+
+// prune must be called with mu held.
+func (store *RevocationStore) prune(now time.Time) {
+	for tokenId, expiresAt := range store.entries.Tokens {
+		if !now.Before(expiresAt) {
+			delete(store.entries.Tokens, tokenId)
+		}
+	}
+	for userId, revocation := range store.entries.Users {
+		if !now.Before(revocation.ExpiresAt) {
+			delete(store.entries.Users, userId)
+		}
+	}
+}
+
+// save must be called with mu held.
+func (store *RevocationStore) save() error {
+	if store.persist == nil {
+		return nil
+	}
+	return store.persist(&store.entries)
+}
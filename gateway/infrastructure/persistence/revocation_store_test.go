@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"gateway/domain"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// revocationStores runs test against the memory store and a file store.
+func revocationStores(t *testing.T, test func(t *testing.T, store domain.RevocationStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewRevocationMemoryStore())
+	})
+	t.Run("file", func(t *testing.T) {
+		store, err := NewRevocationFileStore(filepath.Join(t.TempDir(), "revocations.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+}
+
+func TestRevokeToken(t *testing.T) {
+	revocationStores(t, func(t *testing.T, store domain.RevocationStore) {
+		now := time.Now()
+		store.RevokeToken("revoked", now.Add(time.Hour))
+		store.RevokeToken("expired", now.Add(-time.Second))
+
+		tests := []struct {
+			tokenId string
+			revoked bool
+		}{
+			{"revoked", true},
+			{"expired", false},
+			{"unknown", false},
+		}
+		for _, test := range tests {
+			revoked, err := store.IsTokenRevoked(test.tokenId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != test.revoked {
+				t.Errorf("%s revoked = %v, want %v", test.tokenId, revoked, test.revoked)
+			}
+		}
+	})
+}
+
+func TestRevokeUser(t *testing.T) {
+	revocationStores(t, func(t *testing.T, store domain.RevocationStore) {
+		revokedAt := time.Date(2022, 5, 1, 12, 0, 0, 700_000_000, time.UTC)
+		store.RevokeUser("1", revokedAt, time.Now().Add(time.Hour))
+		store.RevokeUser("2", revokedAt, time.Now().Add(-time.Second))
+
+		at, found, err := store.UserRevokedAt("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatal("revocation of user 1 not found")
+		}
+		if want := revokedAt.Truncate(time.Second); !at.Equal(want) {
+			t.Errorf("revoked at %v, want %v truncated to the second", at, want)
+		}
+		if _, found, _ := store.UserRevokedAt("2"); found {
+			t.Error("expired revocation of user 2 found")
+		}
+		if _, found, _ := store.UserRevokedAt("3"); found {
+			t.Error("revocation of user 3 found")
+		}
+	})
+}
+
+func TestRevocationFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	store, err := NewRevocationFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Now()
+	store.RevokeToken("token", time.Now().Add(time.Hour))
+	store.RevokeUser("1", revokedAt, time.Now().Add(time.Hour))
+
+	reopened, err := NewRevocationFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := reopened.IsTokenRevoked("token"); !revoked {
+		t.Error("revoked token is accepted after a restart")
+	}
+	at, found, _ := reopened.UserRevokedAt("1")
+	if !found || !at.Equal(revokedAt.Truncate(time.Second)) {
+		t.Errorf("user revoked at %v (found %v) after a restart, want %v", at, found, revokedAt.Truncate(time.Second))
+	}
+}
+
+func TestExpiredRevocationsArePruned(t *testing.T) {
+	store := newRevocationStore()
+	store.RevokeToken("expired", time.Now().Add(-time.Second))
+	store.RevokeUser("1", time.Now(), time.Now().Add(-time.Second))
+	store.RevokeToken("current", time.Now().Add(time.Hour))
+
+	if _, ok := store.entries.Tokens["expired"]; ok {
+		t.Error("expired token was kept")
+	}
+	if _, ok := store.entries.Users["1"]; ok {
+		t.Error("expired user revocation was kept")
+	}
+	if _, ok := store.entries.Tokens["current"]; !ok {
+		t.Error("current token was dropped")
+	}
+}
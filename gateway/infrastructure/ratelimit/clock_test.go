@@ -0,0 +1,20 @@
+package ratelimit
+
+import "time"
+
+// clock is a fake clock that only moves when advanced.
+type clock struct {
+	time time.Time
+}
+
+func newClock() *clock {
+	return &clock{time: time.Now()}
+}
+
+func (c *clock) now() time.Time {
+	return c.time
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.time = c.time.Add(d)
+}
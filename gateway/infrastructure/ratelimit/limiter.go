@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets and forgotten failures are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+// Limiter is a set of token buckets, one per key. Buckets are created full and
+// dropped once they have been idle long enough to refill completely.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now is the clock of the limiter, replaced in tests.
+	now func() time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key, which holds up to requests tokens
+// and refills completely every per. When the bucket is empty it returns false
+// and how long it takes until the next token is available.
+func (l *Limiter) Allow(key string, requests int, per time.Duration) (bool, time.Duration) {
+	if requests <= 0 || per <= 0 {
+		return true, 0
+	}
+	now := l.now()
+	rate := float64(requests) / per.Seconds()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(requests), updated: now, per: per}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > float64(requests) {
+		b.tokens = float64(requests)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep must be called with mu held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= b.per {
+			delete(l.buckets, key)
+		}
+	}
+}
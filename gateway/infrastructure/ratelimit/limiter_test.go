@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		allowed bool
+		wait    time.Duration
+	}
+	tests := []struct {
+		name     string
+		requests int
+		per      time.Duration
+		steps    []step
+	}{
+		{
+			name: "burst up to the budget", requests: 3, per: time.Minute,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", false, 20 * time.Second},
+			},
+		},
+		{
+			name: "refill one token at a time", requests: 3, per: time.Minute,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{5 * time.Second, "a", false, 15 * time.Second},
+				{15 * time.Second, "a", true, 0},
+				{0, "a", false, 20 * time.Second},
+			},
+		},
+		{
+			name: "refill up to the budget", requests: 2, per: time.Minute,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{time.Hour, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", false, 30 * time.Second},
+			},
+		},
+		{
+			name: "keys are independent", requests: 1, per: time.Minute,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", false, time.Minute},
+				{0, "b", true, 0},
+			},
+		},
+		{
+			name: "no budget allows everything", requests: 0, per: time.Minute,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newClock()
+			limiter := NewLimiter()
+			limiter.now = clock.now
+			for n, step := range test.steps {
+				clock.advance(step.advance)
+				allowed, wait := limiter.Allow(step.key, test.requests, test.per)
+				if allowed != step.allowed || wait != step.wait {
+					t.Errorf("step %d: got %v, %v, want %v, %v", n, allowed, wait, step.allowed, step.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterDropsIdleBuckets(t *testing.T) {
+	clock := newClock()
+	limiter := NewLimiter()
+	limiter.now = clock.now
+	limiter.Allow("idle", 1, time.Second)
+	limiter.Allow("busy", 1, time.Hour)
+
+	clock.advance(sweepInterval + time.Second)
+	limiter.Allow("other", 1, time.Second)
+
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := limiter.buckets["busy"]; !ok {
+		t.Error("bucket still refilling was dropped")
+	}
+}
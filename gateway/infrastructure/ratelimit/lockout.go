@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// Lockout locks a key out after repeated failures. Once threshold failures
+// happened, every further failure locks the key for twice as long as the one
+// before, starting at duration and capped at maxDuration. Failures are forgotten
+// after a success or when none happened for window.
+type Lockout struct {
+	threshold   int
+	duration    time.Duration
+	maxDuration time.Duration
+	window      time.Duration
+	mu          sync.Mutex
+	failures    map[string]*failures
+	lastSweep   time.Time
+	// now is the clock of the lockout, replaced in tests.
+	now func() time.Time
+}
+
+// NewLockout creates a lockout. A threshold of zero disables it.
+func NewLockout(threshold int, duration time.Duration, maxDuration time.Duration, window time.Duration) *Lockout {
+	return &Lockout{
+		threshold:   threshold,
+		duration:    duration,
+		maxDuration: maxDuration,
+		window:      window,
+		failures:    map[string]*failures{},
+		lastSweep:   time.Now(),
+		now:         time.Now,
+	}
+}
+
+// Check returns how long key stays locked, or zero when it is not locked.
+func (l *Lockout) Check(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	remaining := f.lockedUntil.Sub(l.now())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Fail records a failure for key and returns how long key is now locked.
+func (l *Lockout) Fail(key string) time.Duration {
+	if l.threshold <= 0 {
+		return 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	return l.fail(key, now)
+}
+
+// Reserve starts an attempt for key unless key is locked, in which case it
+// returns false and how long the lock lasts. The attempt counts as a failure
+// right away, so concurrent attempts cannot slip past the threshold while the
+// first ones are still running; it returns how long that failure locks key. A
+// successful attempt calls Succeed and one that did not fail by the fault of
+// the caller calls Release.
+func (l *Lockout) Reserve(key string) (time.Duration, bool) {
+	if l.threshold <= 0 {
+		return 0, true
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	if f, ok := l.failures[key]; ok && f.lockedUntil.After(now) {
+		return f.lockedUntil.Sub(now), false
+	}
+	return l.fail(key, now), true
+}
+
+// Release takes back a failure recorded by Reserve. The lock it set is lifted
+// once the failures are below the threshold again; longer locks stay.
+func (l *Lockout) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[key]
+	if !ok || f.count == 0 {
+		return
+	}
+	f.count--
+	if f.count < l.threshold {
+		f.lockedUntil = time.Time{}
+	}
+}
+
+// fail must be called with mu held.
+func (l *Lockout) fail(key string, now time.Time) time.Duration {
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.last) > l.window {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < l.threshold {
+		return 0
+	}
+
+	lock := l.duration
+	for n := l.threshold; n < f.count && lock < l.maxDuration; n++ {
+		lock *= 2
+	}
+	if lock > l.maxDuration {
+		lock = l.maxDuration
+	}
+	f.lockedUntil = now.Add(lock)
+	return lock
+}
+
+// Succeed forgets the failures of key.
+func (l *Lockout) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// sweep must be called with mu held.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.window && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	const (
+		fail    = "fail"
+		succeed = "succeed"
+		check   = "check"
+	)
+	type step struct {
+		advance time.Duration
+		action  string
+		locked  time.Duration
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name: "below the threshold", threshold: 3,
+			steps: []step{
+				{0, fail, 0},
+				{0, fail, 0},
+				{0, check, 0},
+			},
+		},
+		{
+			name: "lock doubles up to the maximum", threshold: 3,
+			steps: []step{
+				{0, fail, 0},
+				{0, fail, 0},
+				{0, fail, time.Minute},
+				{0, check, time.Minute},
+				{0, fail, 2 * time.Minute},
+				{0, fail, 4 * time.Minute},
+				{0, fail, 4 * time.Minute},
+			},
+		},
+		{
+			name: "lock expires", threshold: 1,
+			steps: []step{
+				{0, fail, time.Minute},
+				{40 * time.Second, check, 20 * time.Second},
+				{20 * time.Second, check, 0},
+			},
+		},
+		{
+			name: "success resets the failures", threshold: 2,
+			steps: []step{
+				{0, fail, 0},
+				{0, fail, time.Minute},
+				{0, succeed, 0},
+				{0, check, 0},
+				{0, fail, 0},
+				{0, fail, time.Minute},
+			},
+		},
+		{
+			name: "failures outside the window are forgotten", threshold: 2,
+			steps: []step{
+				{0, fail, 0},
+				{16 * time.Minute, fail, 0},
+				{time.Minute, fail, time.Minute},
+			},
+		},
+		{
+			name: "no threshold never locks", threshold: 0,
+			steps: []step{
+				{0, fail, 0},
+				{0, fail, 0},
+				{0, check, 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newClock()
+			lockout := NewLockout(test.threshold, time.Minute, 4*time.Minute, 15*time.Minute)
+			lockout.now = clock.now
+			for n, step := range test.steps {
+				clock.advance(step.advance)
+				var locked time.Duration
+				switch step.action {
+				case fail:
+					locked = lockout.Fail("alice")
+				case succeed:
+					lockout.Succeed("alice")
+				case check:
+					locked = lockout.Check("alice")
+				}
+				if locked != step.locked {
+					t.Errorf("step %d (%s): locked for %v, want %v", n, step.action, locked, step.locked)
+				}
+			}
+			if locked := lockout.Check("bob"); locked != 0 {
+				t.Errorf("other key locked for %v", locked)
+			}
+		})
+	}
+}
+
+func TestLockoutReserve(t *testing.T) {
+	clock := newClock()
+	lockout := NewLockout(2, time.Minute, 4*time.Minute, 15*time.Minute)
+	lockout.now = clock.now
+
+	if lock, ok := lockout.Reserve("alice"); !ok || lock != 0 {
+		t.Fatalf("first attempt: got %v, %v", lock, ok)
+	}
+	// The second attempt starts while the first one still runs and reaches the
+	// threshold, so a third one is refused until one of them is released.
+	if lock, ok := lockout.Reserve("alice"); !ok || lock != time.Minute {
+		t.Fatalf("second attempt: got %v, %v", lock, ok)
+	}
+	if wait, ok := lockout.Reserve("alice"); ok || wait != time.Minute {
+		t.Fatalf("third attempt: got %v, %v", wait, ok)
+	}
+	lockout.Release("alice")
+	if locked := lockout.Check("alice"); locked != 0 {
+		t.Fatalf("released attempt left alice locked for %v", locked)
+	}
+	if _, ok := lockout.Reserve("alice"); !ok {
+		t.Fatal("attempt refused after a release")
+	}
+	lockout.Succeed("alice")
+	if lock, ok := lockout.Reserve("alice"); !ok || lock != 0 {
+		t.Fatalf("attempt after a success: got %v, %v", lock, ok)
+	}
+	lockout.Release("bob")
+	if _, ok := lockout.Reserve("bob"); !ok {
+		t.Fatal("releasing an unknown key locked it")
+	}
+}
@@ -0,0 +1,20 @@
+package redact
+
+import "github.com/sirupsen/logrus"
+
+// Formatter redacts every entry before Next formats it, so nothing logged
+// reaches the writer unredacted, whatever logger or hook produced it.
+type Formatter struct {
+	Next     logrus.Formatter
+	Redactor *Redactor
+}
+
+func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = f.Redactor.Text(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		redacted.Data[key] = f.Redactor.Field(key, value)
+	}
+	return f.Next.Format(&redacted)
+}
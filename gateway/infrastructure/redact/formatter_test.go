@@ -0,0 +1,89 @@
+package redact
+
+import (
+	"bytes"
+	"gateway/startup/config"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+// request stands in for a proto request, whose String() is its proto text.
+type request string
+
+func (r request) String() string {
+	return string(r)
+}
+
+// logger logs through a Formatter around next into out.
+func logger(redactor *Redactor, next logrus.Formatter, out *bytes.Buffer) *logrus.Logger {
+	log := logrus.New()
+	log.Out = out
+	log.SetFormatter(&Formatter{Next: next, Redactor: redactor})
+	return log
+}
+
+func TestFormatterRedactsEntries(t *testing.T) {
+	in := request(`credentials:{username:"alice" password:"` + password + `"} email:"` + email + `" recoveryId:"` + recoveryId + `" confirmationId:"` + confirmationId + `" token:"` + token + `"`)
+	formatters := map[string]logrus.Formatter{
+		"json": &logrus.JSONFormatter{},
+		"text": &logrus.TextFormatter{DisableColors: true},
+	}
+	for name, next := range formatters {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := logger(configured(config.NewConfig()), next, &out)
+
+			log.WithFields(logrus.Fields{
+				"password":       password,
+				"token":          token,
+				"recoveryId":     recoveryId,
+				"confirmationId": confirmationId,
+				"email":          email,
+				"authorization":  "Bearer " + bearer,
+			}).Info("Login requested")
+			log.Info("Logging in with " + in.String())
+			log.WithField("request", in).Warn("Request rejected")
+			log.WithField("header", "Bearer "+bearer).Info("Calling with bearer " + bearer)
+			log.WithField("jwt", jwt).Info("Verifying " + jwt)
+
+			if found := leaked(out.String(), secrets); len(found) > 0 {
+				t.Errorf("log leaks %v:\n%s", found, out.String())
+			}
+			for _, kept := range []string{"Login requested", "Logging in with", "Request rejected", `username`, Masked} {
+				if !strings.Contains(out.String(), kept) {
+					t.Errorf("log lacks %q:\n%s", kept, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestFormatterLeavesEntryUnchanged(t *testing.T) {
+	var out bytes.Buffer
+	log := logger(configured(config.NewConfig()), &logrus.JSONFormatter{}, &out)
+	entry := log.WithField("password", password)
+	entry.Info("token=" + token)
+	if entry.Data["password"] != password {
+		t.Error("formatting changed the fields of the entry, which hooks see too")
+	}
+}
+
+func TestFormatterWithConfiguredMaskFields(t *testing.T) {
+	t.Setenv("LOG_MASK_FIELDS", "pin, email")
+	t.Setenv("LOG_HASH_FIELDS", "")
+	const pin = "4921-0373"
+
+	var out bytes.Buffer
+	log := logger(configured(config.NewConfig()), &logrus.JSONFormatter{}, &out)
+	log.WithFields(logrus.Fields{"pin": pin, "email": email, "password": password}).Info("Updating")
+	log.Info(`Updating with PIN:"` + pin + `" email:"` + email + `" and Bearer ` + bearer)
+
+	if found := leaked(out.String(), []string{pin, email, bearer}); len(found) > 0 {
+		t.Errorf("log leaks %v:\n%s", found, out.String())
+	}
+	// The configured fields replace the default ones.
+	if !strings.Contains(out.String(), password) {
+		t.Errorf("password was masked although LOG_MASK_FIELDS leaves it out:\n%s", out.String())
+	}
+}
@@ -0,0 +1,150 @@
+// Package redact keeps personal data and secrets out of the gateway logs.
+//
+// Log fields are matched by name, ignoring case, underscores and dashes, so
+// userId and user_id are the same field. Masked fields are replaced entirely;
+// hashed fields are replaced by a keyed hash of their value, so the entries of
+// one user can still be followed without the log naming them. Messages and the
+// remaining string fields are searched for the same names written as key-value
+// pairs, as in the output of String() on a request, and for bearer tokens and
+// JWTs.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Masked replaces the values of masked fields.
+const Masked = "[REDACTED]"
+
+// Rules names the fields to mask and to hash. Key is the key of the hash; a
+// random key is used when empty, so hashes only match within one run.
+type Rules struct {
+	MaskFields []string
+	HashFields []string
+	Key        []byte
+}
+
+type action int
+
+const (
+	keep action = iota
+	mask
+	hash
+)
+
+type Redactor struct {
+	fields map[string]action
+	key    []byte
+	pairs  *regexp.Regexp
+}
+
+var (
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+func New(rules Rules) *Redactor {
+	r := &Redactor{
+		fields: map[string]action{},
+		key:    rules.Key,
+	}
+	if len(r.key) == 0 {
+		r.key = make([]byte, 32)
+		_, err := rand.Read(r.key)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var names []string
+	for _, field := range rules.HashFields {
+		r.fields[normalize(field)] = hash
+		names = append(names, namePattern(field))
+	}
+	for _, field := range rules.MaskFields {
+		r.fields[normalize(field)] = mask
+		names = append(names, namePattern(field))
+	}
+	if len(names) > 0 {
+		// name:"value" in proto text, "name":"value" in JSON and name=value
+		// in query strings.
+		r.pairs = regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)("?\s*:\s*"|=)((?:[^"\\]|\\.)*"|[^\s&",;]+)`)
+	}
+	return r
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// namePattern matches name with or without separators between its letters.
+func namePattern(name string) string {
+	var pattern strings.Builder
+	for n, c := range normalize(name) {
+		if n > 0 {
+			pattern.WriteString(`[_-]?`)
+		}
+		pattern.WriteString(regexp.QuoteMeta(string(c)))
+	}
+	return pattern.String()
+}
+
+// Field returns the value to log for the field key.
+func (r *Redactor) Field(key string, value interface{}) interface{} {
+	switch r.fields[normalize(key)] {
+	case mask:
+		return Masked
+	case hash:
+		if value == nil || value == "" {
+			return value
+		}
+		return r.Hash(fmt.Sprint(value))
+	}
+	switch v := value.(type) {
+	case string:
+		return r.Text(v)
+	case error:
+		return r.Text(v.Error())
+	case fmt.Stringer:
+		return r.Text(v.String())
+	}
+	return value
+}
+
+// Hash returns the keyed hash value is logged as.
+func (r *Redactor) Hash(value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "h:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Text redacts the values of masked and hashed fields written as key-value
+// pairs in s, along with bearer tokens and JWTs.
+func (r *Redactor) Text(s string) string {
+	if r.pairs != nil {
+		s = r.pairs.ReplaceAllStringFunc(s, func(pair string) string {
+			groups := r.pairs.FindStringSubmatch(pair)
+			name, separator, value := groups[1], groups[2], groups[3]
+			quoted := strings.HasSuffix(separator, `"`)
+			if quoted {
+				value = strings.TrimSuffix(value, `"`)
+			}
+			if r.fields[normalize(name)] == hash {
+				value = r.Hash(value)
+			} else {
+				value = Masked
+			}
+			if quoted {
+				value += `"`
+			}
+			return name + separator + value
+		})
+	}
+	s = bearerPattern.ReplaceAllString(s, "$1 "+Masked)
+	return jwtPattern.ReplaceAllString(s, Masked)
+}
@@ -0,0 +1,43 @@
+package retry
+
+import "sync"
+
+// Budget limits retries to a share of the calls made, so a backend that fails
+// every call is not sent several times its usual load. Every call adds ratio
+// tokens and every retry takes one. The budget starts with and holds at most
+// reserve tokens, which allows a few retries while there is little traffic.
+type Budget struct {
+	mu      sync.Mutex
+	tokens  float64
+	ratio   float64
+	reserve float64
+}
+
+func NewBudget(ratio float64, reserve int) *Budget {
+	return &Budget{
+		tokens:  float64(reserve),
+		ratio:   ratio,
+		reserve: float64(reserve),
+	}
+}
+
+// Deposit records a call.
+func (b *Budget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.reserve {
+		b.tokens = b.reserve
+	}
+}
+
+// Withdraw takes a token for a retry and reports whether there was one.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,47 @@
+package retry
+
+import "testing"
+
+func TestBudgetStartsWithTheReserve(t *testing.T) {
+	b := NewBudget(0.1, 3)
+	for n := 0; n < 3; n++ {
+		if !b.Withdraw() {
+			t.Fatalf("retry %d was denied with tokens in reserve", n+1)
+		}
+	}
+	if b.Withdraw() {
+		t.Error("retry was allowed with the reserve spent")
+	}
+}
+
+func TestBudgetRefillsWithCalls(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratio   float64
+		reserve int
+		calls   int
+		retries int
+	}{
+		{"a tenth of the calls", 0.1, 10, 25, 2},
+		{"half of the calls", 0.5, 10, 7, 3},
+		{"no more than the reserve", 1, 2, 10, 2},
+		{"no retries", 0, 10, 100, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBudget(test.ratio, test.reserve)
+			for b.Withdraw() {
+			}
+			for n := 0; n < test.calls; n++ {
+				b.Deposit()
+			}
+			retries := 0
+			for b.Withdraw() {
+				retries++
+			}
+			if retries != test.retries {
+				t.Errorf("allowed %d retries, want %d", retries, test.retries)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Package retry retries calls to the backend services that failed because the
+// backend was briefly unavailable or overloaded.
+//
+// Only the methods listed as idempotent are retried, since a failed call may
+// still have been carried out by the backend. Retries wait with exponential backoff and full
+// jitter, so the callers of a recovering backend do not return in step, and are
+// limited by a Budget per backend. The deadline of the call bounds all attempts
+// together.
+package retry
+
+import (
+	"context"
+	"gateway/infrastructure/metrics"
+	otgo "github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
+)
+
+// idempotent lists the methods that only read, so calling them again has no
+// effect beyond the first call. Methods are left out unless known to be safe:
+// GetQR2FA, for one, creates a new two-factor secret on every call.
+var idempotent = map[string]bool{
+	"/user.UserService/GetRequest":                   true,
+	"/user.UserService/GetAllRequest":                true,
+	"/user.UserService/SearchUsersRequest":           true,
+	"/user.UserService/GetAllUsersExperienceRequest": true,
+
+	"/post.PostService/GetRequest":                     true,
+	"/post.PostService/GetAllRequest":                  true,
+	"/post.PostService/GetAllFromUserRequest":          true,
+	"/post.PostService/GetCommentRequest":              true,
+	"/post.PostService/GetAllCommentsRequest":          true,
+	"/post.PostService/GetAllCommentsFromPostRequest":  true,
+	"/post.PostService/GetReactionRequest":             true,
+	"/post.PostService/GetAllReactionsRequest":         true,
+	"/post.PostService/GetAllReactionsFromPostRequest": true,
+
+	"/connection.ConnectionService/GetConnection":                    true,
+	"/connection.ConnectionService/GetAllConnections":                true,
+	"/connection.ConnectionService/GetFollowings":                    true,
+	"/connection.ConnectionService/GetFollowers":                     true,
+	"/connection.ConnectionService/GetAllRequestConnectionsByUserId": true,
+	"/connection.ConnectionService/GetAllPendingConnectionsByUserId": true,
+	"/connection.ConnectionService/GetAllSuggestionsByUserId":        true,
+	"/connection.ConnectionService/IsBlocked":                        true,
+	"/connection.ConnectionService/IsBlockedAny":                     true,
+	"/connection.ConnectionService/Blocked":                          true,
+	"/connection.ConnectionService/BlockedBy":                        true,
+	"/connection.ConnectionService/BlockedAny":                       true,
+
+	"/job.JobService/GetRequest":        true,
+	"/job.JobService/GetAllRequest":     true,
+	"/job.JobService/SearchJobsRequest": true,
+
+	"/message.MessageService/GetAllNotifications":   true,
+	"/message.MessageService/GetAllMessagesForUser": true,
+	"/message.MessageService/GetAllChatsForUser":    true,
+}
+
+// Idempotent reports whether the method named by fullMethod, e.g.
+// /connection.ConnectionService/GetFollowers, can safely be called again.
+func Idempotent(fullMethod string) bool {
+	return idempotent[fullMethod]
+}
+
+// Retryable reports whether a call that failed with err may succeed when made
+// again, and how long the backend asked to wait before that. Unavailable calls
+// are retried. ResourceExhausted calls are retried only when the backend tells
+// when to in google.rpc.RetryInfo details; without them the limit may be one
+// that retrying soon cannot lift, such as a quota.
+func Retryable(err error) (time.Duration, bool) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unavailable:
+		return 0, true
+	case codes.ResourceExhausted:
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+				return info.RetryDelay.AsDuration(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Policy is how the calls to one backend are retried. MaxAttempts counts the
+// first attempt too, so 1 turns retries off.
+type Policy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Budget      *Budget
+}
+
+// wait returns how long to wait before the given retry, counted from 1: a
+// random time up to Backoff doubled for every retry before, at most MaxBackoff.
+func (p Policy) wait(retry int) time.Duration {
+	ceiling := p.Backoff
+	for n := 1; n < retry && ceiling < p.MaxBackoff; n++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// UnaryClientInterceptor retries the idempotent calls to backend that failed
+// in a way Retryable accepts, waiting at least as long as the backend asked. It
+// gives up early when the budget is spent, when the backend asked to wait
+// longer than MaxBackoff or when the deadline of the call would pass while
+// waiting.
+func UnaryClientInterceptor(backend string, policy Policy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		policy.Budget.Deposit()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if policy.MaxAttempts <= 1 || !Idempotent(method) {
+			return err
+		}
+		for attempt := 2; attempt <= policy.MaxAttempts; attempt++ {
+			after, retryable := Retryable(err)
+			if !retryable || after > policy.MaxBackoff {
+				return err
+			}
+			wait := policy.wait(attempt - 1)
+			if wait < after {
+				wait = after
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+				return err
+			}
+			if !policy.Budget.Withdraw() {
+				metrics.BackendRetriesDenied.WithLabelValues(backend).Inc()
+				return err
+			}
+			metrics.BackendRetries.WithLabelValues(backend, method, status.Code(err).String()).Inc()
+			if span := otgo.SpanFromContext(ctx); span != nil {
+				span.LogFields(otlog.String("event", "retry"), otlog.Int("attempt", attempt), otlog.String("message", err.Error()))
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return err
+	}
+}
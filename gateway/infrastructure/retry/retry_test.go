@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"testing"
+	"time"
+)
+
+const (
+	getFollowers = "/connection.ConnectionService/GetFollowers"
+	blockUser    = "/connection.ConnectionService/BlockUser"
+	getQR2FA     = "/user.UserService/GetQR2FA"
+)
+
+var (
+	errUnavailable = status.Error(codes.Unavailable, "backend unavailable")
+	errInternal    = status.Error(codes.Internal, "backend failed")
+)
+
+// exhausted returns a ResourceExhausted error, with RetryInfo details asking to
+// wait retryAfter unless it is negative.
+func exhausted(t *testing.T, retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "backend overloaded")
+	if retryAfter < 0 {
+		return st.Err()
+	}
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return detailed.Err()
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{getFollowers, true},
+		{"/connection.ConnectionService/IsBlocked", true},
+		{"/user.UserService/SearchUsersRequest", true},
+		{getQR2FA, false},
+		{blockUser, false},
+		{"/user.UserService/LoginRequest", false},
+		{"/post.PostService/CreateRequest", false},
+		{"/other.Service/GetRequest", false},
+		{"GetFollowers", false},
+	}
+	for _, test := range tests {
+		if got := Idempotent(test.method); got != test.want {
+			t.Errorf("Idempotent(%s) = %v, want %v", test.method, got, test.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		after     time.Duration
+		retryable bool
+	}{
+		{"unavailable", errUnavailable, 0, true},
+		{"resource exhausted with a retry hint", exhausted(t, 2*time.Second), 2 * time.Second, true},
+		{"resource exhausted without a retry hint", exhausted(t, -1), 0, false},
+		{"internal", errInternal, 0, false},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "too slow"), 0, false},
+		{"success", nil, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			after, retryable := Retryable(test.err)
+			if after != test.after || retryable != test.retryable {
+				t.Errorf("got %v, %v, want %v, %v", after, retryable, test.after, test.retryable)
+			}
+		})
+	}
+}
+
+func TestWaitBacksOffExponentially(t *testing.T) {
+	policy := Policy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	tests := []struct {
+		retry   int
+		ceiling time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		{10, 50 * time.Millisecond},
+	}
+	for _, test := range tests {
+		var longest time.Duration
+		for n := 0; n < 500; n++ {
+			wait := policy.wait(test.retry)
+			if wait < 0 || wait > test.ceiling {
+				t.Fatalf("retry %d waited %v, want at most %v", test.retry, wait, test.ceiling)
+			}
+			if wait > longest {
+				longest = wait
+			}
+		}
+		// With full jitter the waits spread over the whole range.
+		if longest <= test.ceiling/2 {
+			t.Errorf("retry %d waited at most %v of %v", test.retry, longest, test.ceiling)
+		}
+	}
+	if wait := (Policy{}).wait(3); wait != 0 {
+		t.Errorf("waited %v without a backoff", wait)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		errs     []error
+		attempts int
+		budget   *Budget
+		timeout  time.Duration
+		calls    int
+		code     codes.Code
+		minWait  time.Duration
+	}{
+		{"recovers after a retry", getFollowers, []error{errUnavailable, nil}, 3, nil, 0, 2, codes.OK, 0},
+		{"gives up after the attempts", getFollowers, []error{errUnavailable, errUnavailable, errUnavailable, nil}, 3, nil, 0, 3, codes.Unavailable, 0},
+		{"retries off", getFollowers, []error{errUnavailable, nil}, 1, nil, 0, 1, codes.Unavailable, 0},
+		{"does not retry writes", blockUser, []error{errUnavailable, nil}, 3, nil, 0, 1, codes.Unavailable, 0},
+		{"does not retry GetQR2FA", getQR2FA, []error{errUnavailable, nil}, 3, nil, 0, 1, codes.Unavailable, 0},
+		{"does not retry other errors", getFollowers, []error{errInternal, nil}, 3, nil, 0, 1, codes.Internal, 0},
+		{"does not retry exhaustion without a hint", getFollowers, []error{exhausted(t, -1), nil}, 3, nil, 0, 1, codes.ResourceExhausted, 0},
+		{"waits as long as the backend asks", getFollowers, []error{exhausted(t, 30*time.Millisecond), nil}, 3, nil, 0, 2, codes.OK, 30 * time.Millisecond},
+		{"does not wait longer than the max backoff", getFollowers, []error{exhausted(t, time.Second), nil}, 3, nil, 0, 1, codes.ResourceExhausted, 0},
+		{"stops when the budget is spent", getFollowers, []error{errUnavailable, errUnavailable, nil}, 3, NewBudget(0, 1), 0, 2, codes.Unavailable, 0},
+		{"stops before the deadline passes", getFollowers, []error{exhausted(t, 60*time.Millisecond), nil}, 3, nil, 20 * time.Millisecond, 1, codes.ResourceExhausted, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			budget := test.budget
+			if budget == nil {
+				budget = NewBudget(0.1, 10)
+			}
+			policy := Policy{MaxAttempts: test.attempts, Backoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond, Budget: budget}
+			calls := 0
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				calls++
+				return test.errs[calls-1]
+			}
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := UnaryClientInterceptor("connection", policy)(ctx, test.method, nil, nil, nil, invoker)
+			if calls != test.calls {
+				t.Errorf("called %d times, want %d", calls, test.calls)
+			}
+			if code := status.Code(err); code != test.code {
+				t.Errorf("got %v, want %v", err, test.code)
+			}
+			if elapsed := time.Since(start); elapsed < test.minWait {
+				t.Errorf("retried after %v, want at least %v", elapsed, test.minWait)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptorStopsWhenCancelled(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Minute, Budget: NewBudget(0.1, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return exhausted(t, time.Second)
+	}
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err := UnaryClientInterceptor("connection", policy)(ctx, getFollowers, nil, nil, nil, invoker)
+	if calls != 1 || status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned %v after the call was cancelled", elapsed)
+	}
+}
@@ -0,0 +1,142 @@
+package sanitize
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Detector recognises one kind of attack in a normalized string value.
+type Detector interface {
+	Name() string
+	// Detect returns a description of the first attack found in value.
+	Detect(value string) (string, bool)
+}
+
+// rule is a pattern that can only match values containing one of its keywords.
+// Checking the keywords first keeps the regular expressions off most values.
+// When context is set, the rule only applies to values it accepts, which keeps
+// patterns that also occur in prose to the values they are an attack in.
+type rule struct {
+	description string
+	keywords    []string
+	pattern     *regexp.Regexp
+	context     func(value string) bool
+}
+
+func (r *rule) match(value string) bool {
+	for _, keyword := range r.keywords {
+		if strings.Contains(value, keyword) {
+			return (r.context == nil || r.context(value)) && r.pattern.MatchString(value)
+		}
+	}
+	return false
+}
+
+// unbalanced accepts values with an odd number of quote, as values that close
+// the string literal they are pasted into and comment out its closing quote
+// have. Quoted prose has them in pairs.
+func unbalanced(quote string) func(value string) bool {
+	return func(value string) bool {
+		return strings.Count(value, quote)%2 == 1
+	}
+}
+
+// isToken accepts values without whitespace, such as ids, file names and
+// paths, as opposed to text.
+func isToken(value string) bool {
+	return !strings.ContainsAny(value, " \t\r\n")
+}
+
+// ruleDetector reports the first of its rules matching a value.
+type ruleDetector struct {
+	name  string
+	rules []rule
+}
+
+func (d *ruleDetector) Name() string {
+	return d.name
+}
+
+func (d *ruleDetector) Detect(value string) (string, bool) {
+	for _, r := range d.rules {
+		if r.match(value) {
+			return r.description, true
+		}
+	}
+	return "", false
+}
+
+// Detectors by the names used in the policy file.
+var Detectors = map[string]Detector{
+	"xss":           Xss,
+	"sql":           SqlInjection,
+	"nosql":         NoSqlInjection,
+	"pathTraversal": PathTraversal,
+}
+
+// Lookup returns the detectors with the given names, or all of them when no
+// names are given.
+func Lookup(names []string) ([]Detector, error) {
+	if len(names) == 0 {
+		return []Detector{Xss, SqlInjection, NoSqlInjection, PathTraversal}, nil
+	}
+	detectors := make([]Detector, 0, len(names))
+	for _, name := range names {
+		detector, ok := Detectors[name]
+		if !ok {
+			return nil, errors.New("unknown detector " + name)
+		}
+		detectors = append(detectors, detector)
+	}
+	return detectors, nil
+}
+
+// Xss finds markup that runs script when rendered as HTML.
+var Xss Detector = &ruleDetector{
+	name: "xss",
+	rules: []rule{
+		{"script capable tag", []string{"<"}, regexp.MustCompile(`<\s*/?\s*(script|iframe|frame|frameset|object|embed|applet|svg|math|style|link|meta|base|form|marquee|template)\b`), nil},
+		{"event handler attribute", []string{"<"}, regexp.MustCompile(`<[^>]*\bon[a-z]+\s*=`), nil},
+		{"script url", []string{"script", "data"}, regexp.MustCompile(`\b(javascript|vbscript|livescript)\s*:|\bdata\s*:\s*text/html`), nil},
+		{"css expression", []string{"expression"}, regexp.MustCompile(`\bexpression\s*\(`), nil},
+	},
+}
+
+// SqlInjection finds fragments that change the meaning of an SQL statement the
+// value is pasted into.
+var SqlInjection Detector = &ruleDetector{
+	name: "sql",
+	rules: []rule{
+		{"union select", []string{"union"}, regexp.MustCompile(`\bunion\b(\s+all)?\s+select\b`), nil},
+		// A statement after a semicolon, such as ; drop table users, but not
+		// ; update me later.
+		{"stacked statement", []string{";"}, regexp.MustCompile(`;\s*(drop\s+(table|database|schema|view|index|user)\b|delete\s+from\b|insert\s+into\b|update\s+\S+\s+set\b|alter\s+(table|database|user)\b|create\s+(table|database|user|function|procedure)\b|truncate\s+table\b|exec(ute)?\s+(xp_|sp_)|shutdown\s*($|--|#|;))`), nil},
+		// A condition after a quote, such as ' or 1=1 or ' or 'a'='a, but
+		// not "yes" or "no".
+		{"tautology", []string{"'", `"`}, regexp.MustCompile(`['"]\s*\)*\s*(or|and)\s+\(*\s*(('[^']*'|"[^"]*"|\d+|[a-z_][a-z0-9_.]*)\s*(=|<>|!=|<|>|\blike\b)|(true|false)\s*($|--|#|/\*|;))`), nil},
+		{"comment after quote", []string{"'"}, regexp.MustCompile(`'\s*\)*\s*;?\s*(--|#|/\*)`), unbalanced("'")},
+		{"comment after quote", []string{`"`}, regexp.MustCompile(`"\s*\)*\s*;?\s*(--|#|/\*)`), unbalanced(`"`)},
+		{"time based probe", []string{"sleep", "benchmark", "waitfor"}, regexp.MustCompile(`\b(sleep|benchmark|pg_sleep)\s*\(|\bwaitfor\s+delay\b`), nil},
+	},
+}
+
+// NoSqlInjection finds MongoDB query operators and server side JavaScript.
+var NoSqlInjection Detector = &ruleDetector{
+	name: "nosql",
+	rules: []rule{
+		{"query operator", []string{"$"}, regexp.MustCompile(`(^|[{,\s"'\[])\$(where|ne|eq|gt|gte|lt|lte|in|nin|regex|expr|or|and|not|nor|exists|type|elemmatch|function|accumulator|lookup|jsonschema)\b`), nil},
+	},
+}
+
+// PathTraversal finds parent directory segments and NUL bytes, which truncate
+// paths in many file APIs. A single parent segment is only an attack in a value
+// without whitespace, such as an id or a path; text may refer to ../ freely.
+var PathTraversal Detector = &ruleDetector{
+	name: "pathTraversal",
+	rules: []rule{
+		{"parent directory segment", []string{".."}, regexp.MustCompile(`(^|[\\/])\.\.([\\/]|$)`), isToken},
+		{"parent directory segments", []string{".."}, regexp.MustCompile(`(\.\.[\\/]){2}`), nil},
+		{"nul byte", []string{"\x00"}, regexp.MustCompile("\x00"), nil},
+	},
+}
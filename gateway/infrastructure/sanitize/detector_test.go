@@ -0,0 +1,82 @@
+package sanitize
+
+import "testing"
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		detector Detector
+		value    string
+		found    bool
+	}{
+		{Xss, "<script>alert(1)</script>", true},
+		{Xss, "<SCRIPT SRC=//evil.example/x.js>", true},
+		{Xss, "<img src=x onerror=alert(1)>", true},
+		{Xss, "&lt;iframe src=//evil.example&gt;", true},
+		{Xss, "<a href=\"javascript:alert(1)\">me</a>", true},
+		{Xss, "%3Csvg%20onload%3Dalert(1)%3E", true},
+		{Xss, "<div style=\"width: expression(alert(1))\">", true},
+		{Xss, "java​script:alert(1)", true},
+		{Xss, "I <3 Go and 2 < 3 > 1", false},
+		{Xss, "Read the description of the script in my profile", false},
+		{Xss, "<b>bold</b> and <i>italic</i>", false},
+
+		{SqlInjection, "' or 1=1--", true},
+		{SqlInjection, "' OR '1'='1", true},
+		{SqlInjection, "\" or \"\"=\"", true},
+		{SqlInjection, "x') or true--", true},
+		{SqlInjection, "admin'--", true},
+		{SqlInjection, "admin' #", true},
+		{SqlInjection, "1' /* comment", true},
+		{SqlInjection, "1 union all select password from users", true},
+		{SqlInjection, "1; DROP TABLE users", true},
+		{SqlInjection, "x'; update users set role='ADMIN'", true},
+		{SqlInjection, "1; exec xp_cmdshell 'dir'", true},
+		{SqlInjection, "1' and sleep(5)", true},
+		{SqlInjection, "'; waitfor delay '0:0:5'--", true},
+		{SqlInjection, "She said \"hi\" -- then left", false},
+		{SqlInjection, "\"#golang\" is trending", false},
+		{SqlInjection, "\"yes\" or \"no\"", false},
+		{SqlInjection, "It's 'fine' and dandy", false},
+		{SqlInjection, "Let's meet; update me on the time", false},
+		{SqlInjection, "The trade union selected a new leader", false},
+		{SqlInjection, "I need some sleep", false},
+
+		{NoSqlInjection, "{\"$where\": \"sleep(100)\"}", true},
+		{NoSqlInjection, "{\"username\": {\"$ne\": null}}", true},
+		{NoSqlInjection, "$gt", true},
+		{NoSqlInjection, "[$regex]=.*", true},
+		{NoSqlInjection, "It costs $5 or $10", false},
+		{NoSqlInjection, "Use $HOME and $PATH", false},
+
+		{PathTraversal, "../../etc/passwd", true},
+		{PathTraversal, "..\\..\\windows\\win.ini", true},
+		{PathTraversal, "images/../secret", true},
+		{PathTraversal, "..", true},
+		{PathTraversal, "%2e%2e%2fetc%2fpasswd", true},
+		{PathTraversal, "see ../../etc/passwd", true},
+		{PathTraversal, "file.txt\x00.png", true},
+		{PathTraversal, "See ../docs for details", false},
+		{PathTraversal, "Well... I guess so", false},
+		{PathTraversal, "v1.2..v1.3", false},
+	}
+	for _, test := range tests {
+		description, found := test.detector.Detect(normalize(test.value))
+		if found != test.found {
+			t.Errorf("%s: %q found = %v (%s), want %v", test.detector.Name(), test.value, found, description, test.found)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	all, err := Lookup(nil)
+	if err != nil || len(all) != len(Detectors) {
+		t.Errorf("got %d detectors (%v), want all %d", len(all), err, len(Detectors))
+	}
+	some, err := Lookup([]string{"sql", "xss"})
+	if err != nil || len(some) != 2 || some[0] != SqlInjection || some[1] != Xss {
+		t.Errorf("got %v (%v), want sql and xss", some, err)
+	}
+	if _, err := Lookup([]string{"ldap"}); err == nil {
+		t.Error("unknown detector was accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package sanitize
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+var jsEscapes = strings.NewReplacer(
+	`\u003c`, "<", `\u003e`, ">", `\x3c`, "<", `\x3e`, ">",
+	`\u0022`, `"`, `\u0027`, "'", `\x22`, `"`, `\x27`, "'",
+)
+
+// maxDecodeRounds bounds how often nested encodings are undone.
+const maxDecodeRounds = 3
+
+// normalize undoes HTML entity, URL and JavaScript escapes, which could hide a
+// payload from the detectors, and lower-cases the result. Characters that
+// browsers ignore inside tags, such as zero width spaces, are dropped.
+func normalize(value string) string {
+	if strings.ContainsAny(value, `&%\`) {
+		for round := 0; round < maxDecodeRounds; round++ {
+			decoded := jsEscapes.Replace(html.UnescapeString(value))
+			if unescaped, err := url.PathUnescape(decoded); err == nil {
+				decoded = unescaped
+			}
+			if decoded == value {
+				break
+			}
+			value = decoded
+		}
+	}
+	if !isAscii(value) {
+		value = strings.Map(func(r rune) rune {
+			switch r {
+			case '\u200b', '\u200c', '\u200d', '\ufeff', '\u00ad':
+				return -1
+			}
+			return r
+		}, value)
+	}
+	return strings.ToLower(value)
+}
+
+func isAscii(value string) bool {
+	for n := 0; n < len(value); n++ {
+		if value[n] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package sanitize
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Modes of handling markup in user generated content.
+const (
+	// ModeReject rejects requests whose content looks like an attack.
+	ModeReject = "reject"
+	// ModeStrip removes every tag and attribute not on the allowlist.
+	ModeStrip = "strip"
+	// ModeEscape escapes all markup so it is shown as text.
+	ModeEscape = "escape"
+)
+
+// Rewriter returns the rewrite function of a mode, or nil for ModeReject.
+func Rewriter(mode string) (func(string) string, error) {
+	switch mode {
+	case ModeReject, "":
+		return nil, nil
+	case ModeStrip:
+		return Strip, nil
+	case ModeEscape:
+		return Escape, nil
+	}
+	return nil, fmt.Errorf("unknown content mode %q", mode)
+}
+
+// allowedTags may be kept by Strip, with the listed attributes.
+var allowedTags = map[atom.Atom][]string{
+	atom.B: nil, atom.I: nil, atom.Em: nil, atom.Strong: nil, atom.U: nil, atom.S: nil,
+	atom.P: nil, atom.Br: nil, atom.Ul: nil, atom.Ol: nil, atom.Li: nil,
+	atom.Blockquote: nil, atom.Code: nil, atom.Pre: nil,
+	atom.A: {"href"},
+}
+
+// droppedWithContent are removed by Strip together with everything inside them.
+var droppedWithContent = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Object: true,
+	atom.Embed: true, atom.Template: true, atom.Noscript: true, atom.Svg: true, atom.Math: true,
+}
+
+// Strip removes every tag not in the allowlist, keeping its text, and every
+// attribute not allowed for its tag. Links keep only http, https and mailto
+// targets. Values without markup are returned unchanged.
+func Strip(value string) string {
+	if !strings.Contains(value, "<") {
+		return value
+	}
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(value))
+	dropping := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return Escape(value)
+			}
+			return out.String()
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedWithContent[token.DataAtom] {
+				if tokenType == html.StartTagToken {
+					dropping++
+				}
+				continue
+			}
+			if dropping > 0 {
+				continue
+			}
+			if attributes, ok := allowedTags[token.DataAtom]; ok {
+				token.Attr = allowedAttributes(token, attributes)
+				out.WriteString(token.String())
+			}
+		case html.EndTagToken:
+			if droppedWithContent[token.DataAtom] {
+				if dropping > 0 {
+					dropping--
+				}
+				continue
+			}
+			if _, ok := allowedTags[token.DataAtom]; ok && dropping == 0 {
+				out.WriteString(token.String())
+			}
+		case html.TextToken:
+			if dropping == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+}
+
+func allowedAttributes(token html.Token, allowed []string) []html.Attribute {
+	var kept []html.Attribute
+	for _, attribute := range token.Attr {
+		if attribute.Namespace != "" || !contains(allowed, attribute.Key) {
+			continue
+		}
+		if attribute.Key == "href" && !isSafeUrl(attribute.Val) {
+			continue
+		}
+		kept = append(kept, attribute)
+	}
+	return kept
+}
+
+func isSafeUrl(value string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// Escape escapes all markup in value so it is shown as text. Values without
+// characters that need escaping are returned unchanged.
+func Escape(value string) string {
+	if !strings.ContainsAny(value, `<>&'"`) {
+		return value
+	}
+	return html.EscapeString(value)
+}
+
+// Rewrite replaces the string fields of msg named in fields, matched by proto
+// or JSON name at any depth, with rewrite applied to them; all string fields are
+// rewritten when fields is empty. Fields named in skipFields are left alone.
+// report is called for every field that changed.
+func Rewrite(msg protoreflect.Message, fields []string, skipFields []string, rewrite func(string) string, report func(field string, original string, rewritten string)) {
+	rewriteMessage(msg, "", names(fields), names(skipFields), rewrite, report)
+}
+
+func rewriteMessage(msg protoreflect.Message, prefix string, fields map[string]bool, skipFields map[string]bool, rewrite func(string) string, report func(string, string, string)) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		path := prefix + fd.JSONName()
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				value.Map().Range(func(key protoreflect.MapKey, mapValue protoreflect.Value) bool {
+					rewriteMessage(mapValue.Message(), fmt.Sprintf("%s[%v].", path, key.Interface()), fields, skipFields, rewrite, report)
+					return true
+				})
+			}
+		case fd.IsList():
+			list := value.List()
+			for n := 0; n < list.Len(); n++ {
+				elementPath := fmt.Sprintf("%s[%d]", path, n)
+				switch fd.Kind() {
+				case protoreflect.StringKind:
+					if rewritten, ok := rewriteString(fd, list.Get(n).String(), fields, skipFields, rewrite); ok {
+						report(elementPath, list.Get(n).String(), rewritten)
+						list.Set(n, protoreflect.ValueOfString(rewritten))
+					}
+				case protoreflect.MessageKind:
+					rewriteMessage(list.Get(n).Message(), elementPath+".", fields, skipFields, rewrite, report)
+				}
+			}
+		case fd.Kind() == protoreflect.StringKind:
+			if rewritten, ok := rewriteString(fd, value.String(), fields, skipFields, rewrite); ok {
+				report(path, value.String(), rewritten)
+				msg.Set(fd, protoreflect.ValueOfString(rewritten))
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			rewriteMessage(value.Message(), path+".", fields, skipFields, rewrite, report)
+		}
+		return true
+	})
+}
+
+func rewriteString(fd protoreflect.FieldDescriptor, value string, fields map[string]bool, skipFields map[string]bool, rewrite func(string) string) (string, bool) {
+	if skipFields[string(fd.Name())] || skipFields[fd.JSONName()] {
+		return "", false
+	}
+	if len(fields) > 0 && !fields[string(fd.Name())] && !fields[fd.JSONName()] {
+		return "", false
+	}
+	rewritten := rewrite(value)
+	return rewritten, rewritten != value
+}
+
+// Diff shows how rewritten differs from original, with the changed middle
+// part marked as [-removed-]{+added+} between a little unchanged context.
+func Diff(original string, rewritten string) string {
+	const context = 20
+	prefix := 0
+	for prefix < len(original) && prefix < len(rewritten) && original[prefix] == rewritten[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(original)-prefix && suffix < len(rewritten)-prefix && original[len(original)-1-suffix] == rewritten[len(rewritten)-1-suffix] {
+		suffix++
+	}
+	before := original[:prefix]
+	if len(before) > context {
+		before = "..." + before[len(before)-context:]
+	}
+	after := original[len(original)-suffix:]
+	if len(after) > context {
+		after = after[:context] + "..."
+	}
+	return before + "[-" + original[prefix:len(original)-suffix] + "-]{+" + rewritten[prefix:len(rewritten)-suffix] + "+}" + after
+}
+
+func names(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, name := range list {
+		set[name] = true
+	}
+	return set
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
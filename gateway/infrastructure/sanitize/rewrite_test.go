@@ -0,0 +1,194 @@
+package sanitize
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"sort"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain text", "Hello & welcome", "Hello & welcome"},
+		{"allowed tags", "<b>bold</b> <em>and</em> <p>more</p>", "<b>bold</b> <em>and</em> <p>more</p>"},
+		{"unknown tag keeps its text", "<span class=x>text</span>", "text"},
+		{"script with content", "a<script>alert(1)</script>b", "ab"},
+		// Script content is raw text up to the first end tag, as in browsers.
+		{"nested script", "<b>x<script>alert(<script>1</script>)</script>y</b>", "<b>x)y</b>"},
+		{"script inside svg", "<svg><script>alert(1)</script><text>t</text></svg>ok", "ok"},
+		{"event handler", "<p onclick=\"alert(1)\">text</p>", "<p>text</p>"},
+		{"javascript link", "<a href=\"javascript:alert(1)\">me</a>", "<a>me</a>"},
+		{"https link", "<a href=\"https://dislinkt.com\" target=\"_blank\">me</a>", "<a href=\"https://dislinkt.com\">me</a>"},
+		{"unclosed tag", "<b>bold", "<b>bold"},
+		{"unclosed script", "text<script>alert(1)", "text"},
+		{"unterminated tag", "text <img src=x onerror=alert(1)", "text "},
+		{"text is escaped", "<i>1 < 2</i>", "<i>1 &lt; 2</i>"},
+	}
+	for _, test := range tests {
+		if got := Strip(test.value); got != test.want {
+			t.Errorf("%s: Strip(%q) = %q, want %q", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain text", "plain text"},
+		{"<script>alert('x')</script>", "&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;"},
+		{"Tom & \"Jerry\"", "Tom &amp; &#34;Jerry&#34;"},
+		{"<b>unclosed", "&lt;b&gt;unclosed"},
+	}
+	for _, test := range tests {
+		if got := Escape(test.value); got != test.want {
+			t.Errorf("Escape(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestIsSafeUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://dislinkt.com", true},
+		{"http://dislinkt.com/a?b=c", true},
+		{"mailto:jobs@dislinkt.com", true},
+		{" HTTPS://dislinkt.com ", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"/relative/path", false},
+		{"%zz", false},
+	}
+	for _, test := range tests {
+		if safe := isSafeUrl(test.url); safe != test.safe {
+			t.Errorf("isSafeUrl(%q) = %v, want %v", test.url, safe, test.safe)
+		}
+	}
+}
+
+// postDescriptor describes a post with nested and repeated content, like the
+// requests of the post service.
+func postDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	msg := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, number int32, label *descriptorpb.FieldDescriptorProto_Label, kind *descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), JsonName: proto.String(name), Number: proto.Int32(number), Label: label, Type: kind}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/post.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Comment"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("content", 1, optional, str, ""),
+				field("userId", 2, optional, str, ""),
+			}},
+			{Name: proto.String("Post"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("content", 1, optional, str, ""),
+				field("userId", 2, optional, str, ""),
+				field("tags", 3, repeated, str, ""),
+				field("comments", 4, repeated, msg, ".test.Comment"),
+				field("pinned", 5, optional, msg, ".test.Comment"),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("Post")
+}
+
+func TestRewriteNestedAndListFields(t *testing.T) {
+	descriptor := postDescriptor(t)
+	comment := descriptor.Fields().ByName("comments").Message()
+	newComment := func(content string) protoreflect.Message {
+		c := dynamicpb.NewMessage(comment)
+		c.Set(comment.Fields().ByName("content"), protoreflect.ValueOfString(content))
+		c.Set(comment.Fields().ByName("userId"), protoreflect.ValueOfString("<b>1</b>"))
+		return c
+	}
+	post := dynamicpb.NewMessage(descriptor)
+	post.Set(descriptor.Fields().ByName("content"), protoreflect.ValueOfString("<i>hi</i><script>x</script>"))
+	post.Set(descriptor.Fields().ByName("userId"), protoreflect.ValueOfString("<b>1</b>"))
+	tags := post.Mutable(descriptor.Fields().ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("<u>go</u>"))
+	tags.Append(protoreflect.ValueOfString("<blink>go</blink>"))
+	comments := post.Mutable(descriptor.Fields().ByName("comments")).List()
+	comments.Append(protoreflect.ValueOfMessage(newComment("ok")))
+	comments.Append(protoreflect.ValueOfMessage(newComment("<img src=x onerror=alert(1)>bad")))
+	post.Set(descriptor.Fields().ByName("pinned"), protoreflect.ValueOfMessage(newComment("<style>*{}</style>pinned")))
+
+	reported := map[string]string{}
+	Rewrite(post, []string{"content", "tags"}, nil, Strip, func(field string, original string, rewritten string) {
+		reported[field] = rewritten
+	})
+
+	// The first tag only holds markup Strip keeps, so it is not rewritten.
+	want := map[string]string{
+		"content":             "<i>hi</i>",
+		"comments[1].content": "bad",
+		"pinned.content":      "pinned",
+		"tags[1]":             "go",
+	}
+	if len(reported) != len(want) {
+		t.Errorf("reported %v, want %v", reported, want)
+	}
+	for field, rewritten := range want {
+		if reported[field] != rewritten {
+			t.Errorf("%s rewritten to %q, want %q", field, reported[field], rewritten)
+		}
+	}
+	if got := post.Get(descriptor.Fields().ByName("content")).String(); got != "<i>hi</i>" {
+		t.Errorf("content is %q", got)
+	}
+	if got := comments.Get(1).Message().Get(comment.Fields().ByName("content")).String(); got != "bad" {
+		t.Errorf("comment content is %q", got)
+	}
+	if got := comments.Get(1).Message().Get(comment.Fields().ByName("userId")).String(); got != "<b>1</b>" {
+		t.Errorf("userId of a comment was rewritten to %q", got)
+	}
+	if got := post.Get(descriptor.Fields().ByName("userId")).String(); got != "<b>1</b>" {
+		t.Errorf("userId was rewritten to %q", got)
+	}
+}
+
+func TestRewriteEveryFieldWhenNoneListed(t *testing.T) {
+	descriptor := postDescriptor(t)
+	post := dynamicpb.NewMessage(descriptor)
+	post.Set(descriptor.Fields().ByName("content"), protoreflect.ValueOfString("<b>x</b>"))
+	post.Set(descriptor.Fields().ByName("userId"), protoreflect.ValueOfString("<b>1</b>"))
+	tags := post.Mutable(descriptor.Fields().ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("<go>"))
+
+	var fields []string
+	Rewrite(post, nil, []string{"userId"}, Escape, func(field string, original string, rewritten string) {
+		fields = append(fields, field)
+	})
+	// Messages are ranged over in no particular order.
+	sort.Strings(fields)
+	if len(fields) != 2 || fields[0] != "content" || fields[1] != "tags[0]" {
+		t.Errorf("rewrote %v, want content and tags[0] but not the skipped userId", fields)
+	}
+	if got := tags.Get(0).String(); got != "&lt;go&gt;" {
+		t.Errorf("tag is %q", got)
+	}
+}
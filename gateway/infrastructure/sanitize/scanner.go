@@ -0,0 +1,115 @@
+package sanitize
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Finding describes the field of a request that triggered a detector.
+type Finding struct {
+	Field       string
+	Detector    string
+	Description string
+}
+
+// Scanner runs detectors over every string field of a message, including
+// nested messages, repeated fields and map keys and values.
+type Scanner struct {
+	detectors  []Detector
+	skipFields map[string]bool
+}
+
+// NewScanner creates a scanner. String fields whose proto or JSON name is listed
+// in skipFields, such as passwords that are only ever hashed, are not scanned.
+func NewScanner(detectors []Detector, skipFields []string) *Scanner {
+	skip := make(map[string]bool, len(skipFields))
+	for _, name := range skipFields {
+		skip[name] = true
+	}
+	return &Scanner{detectors: detectors, skipFields: skip}
+}
+
+// Scan returns the first finding in msg, or nil when it is clean.
+func (s *Scanner) Scan(msg protoreflect.Message) *Finding {
+	return s.scanMessage(msg)
+}
+
+// ScanString returns the first finding in a single value.
+func (s *Scanner) ScanString(field string, value string) *Finding {
+	if value == "" || len(s.detectors) == 0 {
+		return nil
+	}
+	normalized := normalize(value)
+	for _, detector := range s.detectors {
+		if description, found := detector.Detect(normalized); found {
+			return &Finding{Field: field, Detector: detector.Name(), Description: description}
+		}
+	}
+	return nil
+}
+
+// Skips reports whether fd is a string field left out of scanning.
+func (s *Scanner) Skips(fd protoreflect.FieldDescriptor) bool {
+	if fd.Kind() != protoreflect.StringKind {
+		return false
+	}
+	return s.skipFields[string(fd.Name())] || s.skipFields[fd.JSONName()]
+}
+
+// scanMessage returns the first finding in msg with a field path relative to
+// msg. Paths are only built for findings, which keeps scanning clean requests
+// free of allocations.
+func (s *Scanner) scanMessage(msg protoreflect.Message) *Finding {
+	var finding *Finding
+	msg.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if s.Skips(fd) {
+			return true
+		}
+		switch {
+		case fd.IsList():
+			list := value.List()
+			for n := 0; n < list.Len() && finding == nil; n++ {
+				finding = s.scanValue(fd, list.Get(n))
+				if finding != nil {
+					finding.Field = fmt.Sprintf("%s[%d]%s", fd.JSONName(), n, finding.Field)
+				}
+			}
+		case fd.IsMap():
+			value.Map().Range(func(key protoreflect.MapKey, mapValue protoreflect.Value) bool {
+				if fd.MapKey().Kind() == protoreflect.StringKind {
+					finding = s.ScanString("", key.String())
+				}
+				if finding == nil {
+					finding = s.scanValue(fd.MapValue(), mapValue)
+				}
+				if finding != nil {
+					finding.Field = fmt.Sprintf("%s[%v]%s", fd.JSONName(), key.Interface(), finding.Field)
+				}
+				return finding == nil
+			})
+		default:
+			finding = s.scanValue(fd, value)
+			if finding != nil {
+				finding.Field = fd.JSONName() + finding.Field
+			}
+		}
+		return finding == nil
+	})
+	return finding
+}
+
+// scanValue returns the first finding in a single value with a path relative
+// to the field holding it.
+func (s *Scanner) scanValue(fd protoreflect.FieldDescriptor, value protoreflect.Value) *Finding {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return s.ScanString("", value.String())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		finding := s.scanMessage(value.Message())
+		if finding != nil {
+			finding.Field = "." + finding.Field
+		}
+		return finding
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package sanitize
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+	"strings"
+	"testing"
+)
+
+// message builds a request message from JSON like values.
+func message(t testing.TB, fields map[string]interface{}) protoreflect.Message {
+	msg, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.ProtoReflect()
+}
+
+// post resembles a post with comments as the post service receives it.
+func post(t testing.TB, text string, comments int) protoreflect.Message {
+	list := make([]interface{}, comments)
+	for n := range list {
+		list[n] = map[string]interface{}{"userId": "62a8b6f1c3e4a2b1d0f9e8c7", "content": text}
+	}
+	return message(t, map[string]interface{}{
+		"userId":   "62a8b6f1c3e4a2b1d0f9e8c7",
+		"content":  text,
+		"links":    []interface{}{"https://github.com/XWS-BSEP-TIM1-2022", "https://dislinkt.com/profile/62a8b6f1"},
+		"comments": list,
+	})
+}
+
+func TestScanReportsFieldPath(t *testing.T) {
+	all, _ := Lookup(nil)
+	scanner := NewScanner(all, nil)
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		field  string
+	}{
+		{"clean", map[string]interface{}{"content": "Hello", "links": []interface{}{"https://dislinkt.com"}}, ""},
+		{"nested", map[string]interface{}{"post": map[string]interface{}{"content": "<script>"}}, "fields[post].structValue.fields[content].stringValue"},
+		{"list", map[string]interface{}{"links": []interface{}{"ok", "../../etc/passwd"}}, "fields[links].listValue.values[1].stringValue"},
+	}
+	for _, test := range tests {
+		finding := scanner.Scan(message(t, test.fields))
+		field := ""
+		if finding != nil {
+			field = finding.Field
+		}
+		if field != test.field {
+			t.Errorf("%s: finding in %q, want %q", test.name, field, test.field)
+		}
+	}
+}
+
+// BenchmarkScan measures what scanning a clean request costs with all
+// detectors and with each detector on its own.
+func BenchmarkScan(b *testing.B) {
+	plain := "Looking for a Go developer who enjoys distributed systems, gRPC and a good cup of coffee. Apply by Friday!"
+	encoded := "Apply at &lt;a href=&quot;https://dislinkt.com&quot;&gt;dislinkt&lt;/a&gt; or mail jobs%40dislinkt.com, 50% off & more"
+	requests := []struct {
+		name string
+		msg  protoreflect.Message
+	}{
+		{"small post", post(b, plain, 0)},
+		{"encoded post", post(b, encoded, 0)},
+		{"post with 20 comments", post(b, plain, 20)},
+		{"4KB post", post(b, strings.Repeat(plain+" ", 40), 0)},
+	}
+
+	all, _ := Lookup(nil)
+	scanners := []struct {
+		name    string
+		scanner *Scanner
+	}{{"all", NewScanner(all, nil)}}
+	for _, detector := range all {
+		scanners = append(scanners, struct {
+			name    string
+			scanner *Scanner
+		}{detector.Name(), NewScanner([]Detector{detector}, nil)})
+	}
+
+	for _, s := range scanners {
+		b.Run(s.name, func(b *testing.B) {
+			for _, request := range requests {
+				b.Run(request.name, func(b *testing.B) {
+					b.ReportAllocs()
+					for n := 0; n < b.N; n++ {
+						if finding := s.scanner.Scan(request.msg); finding != nil {
+							b.Fatalf("unexpected finding in %s: %+v", finding.Field, finding)
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package verifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keySetFile is the on-disk format of the key set:
+//
+//	{"keys": [
+//	  {"kid": "2022-06", "alg": "HS256", "secret": "..."},
+//	  {"kid": "2022-07", "alg": "RS256", "publicKeyFile": "jwt_2022-07.pem"}
+//	]}
+//
+// Relative public key paths are resolved against the directory of the key set.
+type keySetFile struct {
+	Keys []keyFile `json:"keys"`
+}
+
+type keyFile struct {
+	Kid           string `json:"kid"`
+	Alg           string `json:"alg"`
+	Secret        string `json:"secret"`
+	PublicKeyFile string `json:"publicKeyFile"`
+}
+
+type key struct {
+	kid    string
+	method jwt.SigningMethod
+	value  interface{}
+}
+
+func loadKeySet(path string) ([]key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := keySetFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, errors.New(path + " contains no keys")
+	}
+
+	keys := make([]key, 0, len(file.Keys))
+	for _, k := range file.Keys {
+		parsed, err := parseKey(k, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("key %q in %s: %w", k.Kid, path, err)
+		}
+		keys = append(keys, parsed)
+	}
+	return keys, nil
+}
+
+func parseKey(k keyFile, dir string) (key, error) {
+	method := jwt.GetSigningMethod(k.Alg)
+	if method == nil {
+		return key{}, errors.New("unsupported algorithm " + k.Alg)
+	}
+
+	if strings.HasPrefix(k.Alg, "HS") {
+		if k.Secret == "" {
+			return key{}, errors.New("missing secret")
+		}
+		return key{kid: k.Kid, method: method, value: []byte(k.Secret)}, nil
+	}
+
+	if k.PublicKeyFile == "" {
+		return key{}, errors.New("missing publicKeyFile")
+	}
+	path := k.PublicKeyFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return key{}, err
+	}
+	var value interface{}
+	switch {
+	case strings.HasPrefix(k.Alg, "RS"), strings.HasPrefix(k.Alg, "PS"):
+		value, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+	case strings.HasPrefix(k.Alg, "ES"):
+		value, err = jwt.ParseECPublicKeyFromPEM(pem)
+	default:
+		err = errors.New("unsupported algorithm " + k.Alg)
+	}
+	if err != nil {
+		return key{}, err
+	}
+	return key{kid: k.Kid, method: method, value: value}, nil
+}
@@ -0,0 +1,164 @@
+package verifier
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// ClaimNames are the names of the claims holding the user id, the role and
+// whether the second login factor is still pending.
+type ClaimNames struct {
+	UserId     string
+	Role       string
+	TfaPending string
+}
+
+// Claims are the contents of a JWT.
+type Claims struct {
+	Id         string
+	UserId     string
+	Role       string
+	TfaPending bool
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// Verifier checks JWT signatures and expiry against the keys of a key set file.
+// The file can be replaced while the gateway runs; see Watch.
+type Verifier struct {
+	path    string
+	names   ClaimNames
+	mu      sync.RWMutex
+	keys    []key
+	modTime time.Time
+}
+
+func NewVerifier(path string, names ClaimNames) (*Verifier, error) {
+	v := &Verifier{path: path, names: names}
+	err := v.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload reads the key set file again and replaces the keys in use. The old
+// keys stay in use if the file cannot be loaded.
+func (v *Verifier) Reload() error {
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return err
+	}
+	keys, err := loadKeySet(v.path)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.modTime = info.ModTime()
+	v.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the key set whenever the file's modification time changes,
+// checking every interval until stop is closed.
+func (v *Verifier) Watch(interval time.Duration, stop <-chan struct{}, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(v.path)
+			if err != nil {
+				log.Warn("Failed to check key set " + v.path + ": " + err.Error())
+				continue
+			}
+			v.mu.RLock()
+			changed := !info.ModTime().Equal(v.modTime)
+			v.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = v.Reload()
+			if err != nil {
+				log.Warn("Failed to reload key set " + v.path + ", keeping previous keys: " + err.Error())
+				continue
+			}
+			log.Info("Reloaded key set " + v.path)
+		}
+	}
+}
+
+// Verify checks the signature and expiry of raw, which may carry a "Bearer "
+// prefix, and returns its claims. Tokens naming a key id are only checked
+// against that key; tokens without one are checked against every key using the
+// token's algorithm, so a rotated key can be added next to the old one.
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+	raw = strings.TrimSpace(strings.TrimPrefix(raw, "Bearer "))
+
+	v.mu.RLock()
+	keys := v.keys
+	v.mu.RUnlock()
+
+	for _, k := range keys {
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			if kid != "" && kid != k.kid {
+				return nil, ErrInvalidToken
+			}
+			if token.Method.Alg() != k.method.Alg() {
+				return nil, ErrInvalidToken
+			}
+			return k.value, nil
+		})
+		if err == nil {
+			return claimsOf(claims, v.names)
+		}
+		// Only report expiry for tokens whose signature matched this key.
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+			return nil, ErrTokenExpired
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// UnverifiedClaims returns the claims of raw without checking its signature. It
+// must only be used for tokens that were already verified by the user service.
+func UnverifiedClaims(raw string, names ClaimNames) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(strings.TrimSpace(strings.TrimPrefix(raw, "Bearer ")), claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claimsOf(claims, names)
+}
+
+func claimsOf(claims jwt.MapClaims, names ClaimNames) (*Claims, error) {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	parsed := &Claims{ExpiresAt: time.Unix(int64(exp), 0)}
+	if iat, ok := claims["iat"].(float64); ok {
+		parsed.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	parsed.Id, _ = claims["jti"].(string)
+	parsed.UserId, _ = claims[names.UserId].(string)
+	parsed.Role, _ = claims[names.Role].(string)
+	parsed.TfaPending, _ = claims[names.TfaPending].(bool)
+	return parsed, nil
+}
@@ -0,0 +1,246 @@
+package verifier
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var names = ClaimNames{UserId: "userId", Role: "role", TfaPending: "tfaPending"}
+
+// keySet writes a key set holding the HS256 keys "old" and "new" and the RS256
+// key "rsa" to a new directory, and returns its path and the RSA private key.
+func keySet(t *testing.T) (string, *rsa.PrivateKey) {
+	dir := t.TempDir()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "rsa.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keys.json")
+	writeKeySet(t, path, `{"keys": [
+		{"kid": "old", "alg": "HS256", "secret": "old secret"},
+		{"kid": "new", "alg": "HS256", "secret": "new secret"},
+		{"kid": "rsa", "alg": "RS256", "publicKeyFile": "rsa.pem"}
+	]}`)
+	return path, private
+}
+
+// writeKeySet replaces the key set at path, moving its modification time on so
+// Watch notices even within the resolution of the file system clock.
+func writeKeySet(t *testing.T, path, contents string) {
+	info, statErr := os.Stat(path)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if statErr == nil {
+		later := info.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sign returns a token of user 1 signed with key by method, with kid in its
+// header unless empty, that expires at expiresAt.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, expiresAt time.Time) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"jti":    "token-1",
+		"userId": "1",
+		"role":   "USER",
+		"iat":    time.Now().Unix(),
+		"exp":    expiresAt.Unix(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	path, private := keySet(t)
+	v, err := NewVerifier(path, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPem, err := os.ReadFile(filepath.Join(filepath.Dir(path), "rsa.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"userId": "1", "exp": valid.Unix()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"key picked by kid", sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), valid), nil},
+		{"older key picked by kid", sign(t, jwt.SigningMethodHS256, "old", []byte("old secret"), valid), nil},
+		{"RSA key picked by kid", sign(t, jwt.SigningMethodRS256, "rsa", private, valid), nil},
+		{"key found without kid", sign(t, jwt.SigningMethodHS256, "", []byte("new secret"), valid), nil},
+		{"bearer prefix", "Bearer " + sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), valid), nil},
+		{"unknown kid", sign(t, jwt.SigningMethodHS256, "retired", []byte("new secret"), valid), ErrInvalidToken},
+		{"kid of another key", sign(t, jwt.SigningMethodHS256, "old", []byte("new secret"), valid), ErrInvalidToken},
+		{"unknown secret", sign(t, jwt.SigningMethodHS256, "", []byte("guessed"), valid), ErrInvalidToken},
+		{"wrong alg for the key", sign(t, jwt.SigningMethodHS384, "new", []byte("new secret"), valid), ErrInvalidToken},
+		{"public key used as HMAC secret", sign(t, jwt.SigningMethodHS256, "rsa", rsaPem, valid), ErrInvalidToken},
+		{"alg none", unsigned, ErrInvalidToken},
+		{"expired", sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), expired), ErrTokenExpired},
+		{"expired with a bad signature", sign(t, jwt.SigningMethodHS256, "new", []byte("guessed"), expired), ErrInvalidToken},
+		{"malformed", "not.a.token", ErrInvalidToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := v.Verify(test.token)
+			if err != test.err {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if err == nil && (claims.UserId != "1" || claims.Role != "USER" || claims.Id != "token-1" || claims.ExpiresAt.Unix() != valid.Unix()) {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRequiresExpiry(t *testing.T) {
+	path, _ := keySet(t)
+	v, err := NewVerifier(path, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userId": "1"}).SignedString([]byte("new secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(token); err != ErrInvalidToken {
+		t.Errorf("got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestNewVerifierRejectsInvalidKeySets(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"malformed", `{"keys": [`},
+		{"no keys", `{"keys": []}`},
+		{"unsupported algorithm", `{"keys": [{"kid": "a", "alg": "XX256", "secret": "s"}]}`},
+		{"missing secret", `{"keys": [{"kid": "a", "alg": "HS256"}]}`},
+		{"missing public key", `{"keys": [{"kid": "a", "alg": "RS256", "publicKeyFile": "missing.pem"}]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			writeKeySet(t, path, test.contents)
+			if _, err := NewVerifier(path, names); err == nil {
+				t.Error("loaded an invalid key set")
+			}
+		})
+	}
+}
+
+func TestReloadKeepsKeysOfMalformedKeySet(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		reloaded bool
+	}{
+		{"malformed", `{"keys": [{"kid": "newest"`, false},
+		{"invalid key", `{"keys": [{"kid": "newest", "alg": "HS256"}]}`, false},
+		{"rotated", `{"keys": [{"kid": "newest", "alg": "HS256", "secret": "newest secret"}]}`, true},
+	}
+	valid := time.Now().Add(time.Hour)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _ := keySet(t)
+			v, err := NewVerifier(path, names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeKeySet(t, path, test.contents)
+			if err := v.Reload(); (err == nil) != test.reloaded {
+				t.Fatalf("reload returned %v", err)
+			}
+
+			_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), valid))
+			if kept := err == nil; kept == test.reloaded {
+				t.Errorf("previous key kept = %v, want %v", kept, !test.reloaded)
+			}
+			_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "newest", []byte("newest secret"), valid))
+			if rotated := err == nil; rotated != test.reloaded {
+				t.Errorf("new key in use = %v, want %v", rotated, test.reloaded)
+			}
+		})
+	}
+}
+
+func TestWatchReloadsChangedKeySet(t *testing.T) {
+	path, _ := keySet(t)
+	v, err := NewVerifier(path, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.Out = io.Discard
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		v.Watch(5*time.Millisecond, stop, log)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	valid := time.Now().Add(time.Hour)
+	verifies := func(kid, secret string) bool {
+		_, err := v.Verify(sign(t, jwt.SigningMethodHS256, kid, []byte(secret), valid))
+		return err == nil
+	}
+	eventually := func(condition func() bool) bool {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if condition() {
+				return true
+			}
+		}
+		return false
+	}
+
+	writeKeySet(t, path, `{"keys": [`)
+	time.Sleep(30 * time.Millisecond)
+	if !verifies("new", "new secret") {
+		t.Fatal("a malformed key set replaced the previous keys")
+	}
+	writeKeySet(t, path, `{"keys": [{"kid": "newest", "alg": "HS256", "secret": "newest secret"}]}`)
+	if !eventually(func() bool { return verifies("newest", "newest secret") }) {
+		t.Fatal("rotated key set was not loaded")
+	}
+	if verifies("new", "new secret") {
+		t.Error("key removed from the key set is still accepted")
+	}
+}
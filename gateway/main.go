@@ -2,6 +2,7 @@ package main
 
 import (
 	"gateway/infrastructure/api"
+	"gateway/infrastructure/redact"
 	"gateway/startup"
 	"gateway/startup/config"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -13,8 +14,24 @@ import (
 var log = logrus.New()
 
 func main() {
+	config := config.NewConfig()
 	api.Log = log
 	log.Out = os.Stdout
+	var formatter logrus.Formatter = &logrus.TextFormatter{}
+	if config.LogFormat == "json" {
+		formatter = &logrus.JSONFormatter{}
+	}
+	if config.LogRedaction {
+		formatter = &redact.Formatter{
+			Next: formatter,
+			Redactor: redact.New(redact.Rules{
+				MaskFields: config.LogMaskFields,
+				HashFields: config.LogHashFields,
+				Key:        []byte(config.LogRedactionKey),
+			}),
+		}
+	}
+	log.SetFormatter(formatter)
 
 	path := "gateway.log"
 	writer, err := rotatelogs.New(
@@ -30,8 +47,17 @@ func main() {
 		log.Info("Failed to log to file, using default stderr")
 	}
 
-	config := config.NewConfig()
 	server, _ := startup.NewServer(config)
-	server.Start()
 	log.Info("Server staring...")
+	err = server.Start()
+	if err != nil {
+		log.Error("Server failed: " + err.Error())
+	}
+	log.Info("Server stopped")
+	if writer != nil {
+		writer.Close()
+	}
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -1,45 +1,147 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	GrpcPort              string
-	HttpPort              string
-	UserServiceHost       string
-	UserServicePort       string
-	PostServiceHost       string
-	PostServicePort       string
-	CertificatePath       string
-	CertificateKeyPath    string
-	ConnectionServiceHost string
-	ConnectionServicePort string
-	JobServiceHost        string
-	JobServicePort        string
-	MessageServiceHost    string
-	MessageServicePort    string
-	RolePermissions       map[string][]string
+	GrpcPort                string
+	HttpPort                string
+	UserServiceHost         string
+	UserServicePort         string
+	PostServiceHost         string
+	PostServicePort         string
+	CertificatePath         string
+	CertificateKeyPath      string
+	ConnectionServiceHost   string
+	ConnectionServicePort   string
+	JobServiceHost          string
+	JobServicePort          string
+	MessageServiceHost      string
+	MessageServicePort      string
+	PolicyPath              string
+	JwtKeySetPath           string
+	JwtKeySetReload         time.Duration
+	JwtUserIdClaim          string
+	JwtRoleClaim            string
+	JwtTfaPendingClaim      string
+	JwtRevocationCheck      bool
+	AuthCacheSize           int
+	AuthCacheTtl            time.Duration
+	ApiTokenScopesPath      string
+	JwtLifetime             time.Duration
+	RevocationStore         string
+	RevocationStorePath     string
+	LoginLockoutThreshold   int
+	LoginLockoutDuration    time.Duration
+	LoginLockoutMaxDuration time.Duration
+	LoginFailureWindow      time.Duration
+	UsageStore              string
+	UsageStorePath          string
+	UsageFlushInterval      time.Duration
+	ValidationPath          string
+	MaxRequestBodySize      ByteSize
+	GrpcMaxRecvMsgSize      ByteSize
+	GrpcMaxSendMsgSize      ByteSize
+	HttpMaxHeaderSize       ByteSize
+	HttpReadHeaderTimeout   time.Duration
+	HttpReadTimeout         time.Duration
+	HttpIdleTimeout         time.Duration
+	ShutdownDelay           time.Duration
+	ShutdownTimeout         time.Duration
+	HealthCheckInterval     time.Duration
+	OptionalBackends        []string
+	MetricsPort             string
+	LogFormat               string
+	AuditLogPath            string
+	AuditLogKey             string
+	AuditAnchorInterval     time.Duration
+	LogRedaction            bool
+	LogMaskFields           []string
+	LogHashFields           []string
+	LogRedactionKey         string
+	BackendTimeout          time.Duration
+	BackendTimeouts         map[string]time.Duration
+	MaxCallTimeout          time.Duration
+	RetryMaxAttempts        int
+	RetryAttempts           map[string]int
+	RetryBackoff            time.Duration
+	RetryMaxBackoff         time.Duration
+	RetryBudgetRatio        float64
+	RetryBudgetReserve      int
+	AdminBootstrap          bool
 }
 
 func NewConfig() *Config {
 	return &Config{
-		GrpcPort:              getEnv("GATEWAY_GRPC_PORT", "8080"),
-		HttpPort:              getEnv("GATEWAY_HTTP_PORT", "8090"),
-		UserServiceHost:       getEnv("USER_SERVICE_HOST", "localhost"),
-		UserServicePort:       getEnv("USER_SERVICE_PORT", "8085"),
-		PostServiceHost:       getEnv("POST_SERVICE_HOST", "localhost"),
-		PostServicePort:       getEnv("POST_SERVICE_PORT", "8086"),
-		ConnectionServiceHost: getEnv("CONNECTION_SERVICE_HOST", "localhost"),
-		ConnectionServicePort: getEnv("CONNECTION_SERVICE_PORT", "8087"),
-		JobServiceHost:        getEnv("JOB_SERVICE_HOST", "localhost"),
-		JobServicePort:        getEnv("JOB_SERVICE_PORT", "8088"),
-		MessageServiceHost:    getEnv("MESSAGE_SERVICE_HOST", "localhost"),
-		MessageServicePort:    getEnv("MESSAGE_SERVICE_PORT", "8089"),
-		CertificatePath:       getEnv("CERTIFICATE_PATH", "certificates/dislinkt.cer"),
-		CertificateKeyPath:    getEnv("CERTIFICATE_KEY_PATH", "certificates/dislinkt_private_key.key"),
-		RolePermissions: map[string][]string{
-			"ADMIN": []string{"user_getAll", "user_read", "user_write", "user_delete", "post_read", "post_write", "post_delete", "post_getAll", "job_read", "job_write", "job_delete", "connection_read", "connection_write", "connection_delete", "message_read", "message_write", "chat_read", "chat_write"},
-			"USER":  []string{"post_read", "user_read", "user_write", "post_write", "post_delete", "job_read", "job_write", "job_delete", "connection_read", "connection_write", "connection_delete", "block_write", "block_read", "notification_read", "message_read", "message_write", "chat_read", "chat_write"},
-		},
+		GrpcPort:                getEnv("GATEWAY_GRPC_PORT", "8080"),
+		HttpPort:                getEnv("GATEWAY_HTTP_PORT", "8090"),
+		UserServiceHost:         getEnv("USER_SERVICE_HOST", "localhost"),
+		UserServicePort:         getEnv("USER_SERVICE_PORT", "8085"),
+		PostServiceHost:         getEnv("POST_SERVICE_HOST", "localhost"),
+		PostServicePort:         getEnv("POST_SERVICE_PORT", "8086"),
+		ConnectionServiceHost:   getEnv("CONNECTION_SERVICE_HOST", "localhost"),
+		ConnectionServicePort:   getEnv("CONNECTION_SERVICE_PORT", "8087"),
+		JobServiceHost:          getEnv("JOB_SERVICE_HOST", "localhost"),
+		JobServicePort:          getEnv("JOB_SERVICE_PORT", "8088"),
+		MessageServiceHost:      getEnv("MESSAGE_SERVICE_HOST", "localhost"),
+		MessageServicePort:      getEnv("MESSAGE_SERVICE_PORT", "8089"),
+		CertificatePath:         getEnv("CERTIFICATE_PATH", "certificates/dislinkt.cer"),
+		CertificateKeyPath:      getEnv("CERTIFICATE_KEY_PATH", "certificates/dislinkt_private_key.key"),
+		PolicyPath:              getEnv("GATEWAY_POLICY_PATH", "policy.yml"),
+		JwtKeySetPath:           getEnv("JWT_KEY_SET_PATH", ""),
+		JwtKeySetReload:         getEnvDuration("JWT_KEY_SET_RELOAD", 30*time.Second),
+		JwtUserIdClaim:          getEnv("JWT_USER_ID_CLAIM", "userId"),
+		JwtRoleClaim:            getEnv("JWT_ROLE_CLAIM", "role"),
+		JwtTfaPendingClaim:      getEnv("JWT_TFA_PENDING_CLAIM", "tfaPending"),
+		JwtRevocationCheck:      getEnvBool("JWT_REVOCATION_CHECK", true),
+		AuthCacheSize:           getEnvInt("AUTH_CACHE_SIZE", 10000),
+		AuthCacheTtl:            getEnvDuration("AUTH_CACHE_TTL", 30*time.Second),
+		ApiTokenScopesPath:      getEnv("API_TOKEN_SCOPES_PATH", "data/api_token_scopes.json"),
+		JwtLifetime:             getEnvDuration("JWT_LIFETIME", 24*time.Hour),
+		RevocationStore:         getEnv("REVOCATION_STORE", "file"),
+		RevocationStorePath:     getEnv("REVOCATION_STORE_PATH", "data/revocations.json"),
+		LoginLockoutThreshold:   getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		LoginLockoutDuration:    getEnvDuration("LOGIN_LOCKOUT_DURATION", time.Minute),
+		LoginLockoutMaxDuration: getEnvDuration("LOGIN_LOCKOUT_MAX_DURATION", time.Hour),
+		LoginFailureWindow:      getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		UsageStore:              getEnv("USAGE_STORE", "file"),
+		UsageStorePath:          getEnv("USAGE_STORE_PATH", "data/api_token_usage.json"),
+		UsageFlushInterval:      getEnvDuration("USAGE_FLUSH_INTERVAL", 10*time.Second),
+		ValidationPath:          getEnv("GATEWAY_VALIDATION_PATH", "validation.yml"),
+		MaxRequestBodySize:      getEnvByteSize("MAX_REQUEST_BODY_SIZE", 1<<20),
+		GrpcMaxRecvMsgSize:      getEnvByteSize("GRPC_MAX_RECV_MSG_SIZE", 4<<20),
+		GrpcMaxSendMsgSize:      getEnvByteSize("GRPC_MAX_SEND_MSG_SIZE", 16<<20),
+		HttpMaxHeaderSize:       getEnvByteSize("HTTP_MAX_HEADER_SIZE", 64<<10),
+		HttpReadHeaderTimeout:   getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HttpReadTimeout:         getEnvDuration("HTTP_READ_TIMEOUT", time.Minute),
+		HttpIdleTimeout:         getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		ShutdownDelay:           getEnvDuration("SHUTDOWN_DELAY", 0),
+		ShutdownTimeout:         getEnvDuration("SHUTDOWN_TIMEOUT", 8*time.Second),
+		HealthCheckInterval:     getEnvDuration("HEALTH_CHECK_INTERVAL", 5*time.Second),
+		OptionalBackends:        getEnvList("OPTIONAL_BACKENDS", nil),
+		MetricsPort:             getEnv("METRICS_PORT", "8091"),
+		LogFormat:               getEnv("LOG_FORMAT", "json"),
+		AuditLogPath:            getEnv("AUDIT_LOG_PATH", "data/audit.log"),
+		AuditLogKey:             getEnv("AUDIT_LOG_KEY", ""),
+		AuditAnchorInterval:     getEnvDuration("AUDIT_ANCHOR_INTERVAL", time.Minute),
+		LogRedaction:            getEnvBool("LOG_REDACTION", true),
+		LogMaskFields:           getEnvList("LOG_MASK_FIELDS", []string{"password", "newPassword", "oldPassword", "token", "apiToken", "jwt", "authorization", "secret", "confirmationId", "recoveryId"}),
+		LogHashFields:           getEnvList("LOG_HASH_FIELDS", []string{"username", "email", "userId", "connectedUserId", "blockUserId", "loggedUserId"}),
+		LogRedactionKey:         getEnv("LOG_REDACTION_KEY", ""),
+		BackendTimeout:          getEnvDuration("BACKEND_TIMEOUT", 10*time.Second),
+		BackendTimeouts:         getEnvDurations("BACKEND_TIMEOUTS"),
+		MaxCallTimeout:          getEnvDuration("MAX_CALL_TIMEOUT", time.Minute),
+		RetryMaxAttempts:        getEnvInt("RETRY_MAX_ATTEMPTS", 3),
+		RetryAttempts:           getEnvInts("RETRY_ATTEMPTS"),
+		RetryBackoff:            getEnvDuration("RETRY_BACKOFF", 50*time.Millisecond),
+		RetryMaxBackoff:         getEnvDuration("RETRY_MAX_BACKOFF", time.Second),
+		RetryBudgetRatio:        getEnvFloat("RETRY_BUDGET_RATIO", 0.1),
+		RetryBudgetReserve:      getEnvInt("RETRY_BUDGET_RESERVE", 10),
+		AdminBootstrap:          getEnvBool("ADMIN_BOOTSTRAP", false),
 	}
 }
 
@@ -49,3 +151,101 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		parsed, err := strconv.Atoi(value)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// getEnvInts reads numbers by name such as "post=4,user=1". Invalid entries are
+// skipped.
+func getEnvInts(key string) map[string]int {
+	ints := map[string]int{}
+	for _, item := range getEnvList(key, nil) {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil {
+			ints[strings.TrimSpace(name)] = parsed
+		}
+	}
+	return ints
+}
+
+// getEnvList reads a comma separated list such as "job,message".
+func getEnvList(key string, fallback []string) []string {
+	if value, ok := os.LookupEnv(key); ok {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		parsed, err := time.ParseDuration(value)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// getEnvDurations reads durations by name such as "user=5s,connection=20s".
+// Invalid entries are skipped.
+func getEnvDurations(key string) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for _, item := range getEnvList(key, nil) {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err == nil {
+			durations[strings.TrimSpace(name)] = parsed
+		}
+	}
+	return durations
+}
+
+func getEnvByteSize(key string, fallback ByteSize) ByteSize {
+	if value, ok := os.LookupEnv(key); ok {
+		parsed, err := ParseByteSize(value)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,337 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes who may call which gRPC method. It is loaded from the file
+// at Config.PolicyPath when the gateway starts.
+//
+// ApiTokenScopes lists the scopes a user may choose when creating an API token;
+// tokens created without choosing any get DefaultApiTokenScopes. API tokens are
+// limited by the quota of the plan listing their user, or of DefaultApiTokenPlan.
+type Policy struct {
+	Roles                 map[string][]string      `yaml:"roles"`
+	OwnerOverrideRoles    []string                 `yaml:"ownerOverrideRoles"`
+	ApiTokenScopes        []string                 `yaml:"apiTokenScopes"`
+	DefaultApiTokenScopes []string                 `yaml:"defaultApiTokenScopes"`
+	ApiTokenPlans         map[string]*ApiTokenPlan `yaml:"apiTokenPlans"`
+	DefaultApiTokenPlan   string                   `yaml:"defaultApiTokenPlan"`
+	Sanitize              SanitizePolicy           `yaml:"sanitize"`
+	Methods               map[string]*MethodPolicy `yaml:"methods"`
+}
+
+// SanitizePolicy selects the detectors every request is scanned with and the
+// names of fields that are never scanned.
+type SanitizePolicy struct {
+	Detectors  []string `yaml:"detectors"`
+	SkipFields []string `yaml:"skipFields"`
+}
+
+// ApiTokenPlan is the quota of the API tokens of Users. Zero means unlimited.
+type ApiTokenPlan struct {
+	PerMinute int64    `yaml:"perMinute"`
+	PerDay    int64    `yaml:"perDay"`
+	Users     []string `yaml:"users"`
+}
+
+// MethodPolicy is the access rule for a single full gRPC method name such as
+// /user.UserService/UpdateRequest. A method that is neither denied nor public
+// requires an authenticated caller whose role is listed in Roles (when set) and
+// who holds every permission in Permissions.
+//
+// Owner lists dotted paths of request fields holding user ids, e.g.
+// "connection.userId"; when set, at least one of them must equal the id of the
+// caller unless the caller's role is one of the owner override roles.
+//
+// Scopes opens the method to API tokens holding every listed scope. API tokens
+// carry no role, so Roles and Permissions only apply to JWTs. Methods without
+// scopes cannot be called with an API token; public ones serve such callers
+// anonymously. ApiTokenOnly closes a method with scopes to JWTs.
+//
+// Tokens whose second login factor is still pending may only call methods with
+// AllowTfaPending set; public methods serve them anonymously otherwise.
+//
+// RateLimit sets the request budgets of the method. Lockout names the request
+// field, e.g. "credentials.username", whose value is locked out after repeated
+// failed calls.
+//
+// Content selects how user generated content in the request is handled.
+// MaxBodySize overrides the size limit of request bodies, e.g. for requests
+// carrying images. Audit records every call of the method in the audit log.
+// Timeout overrides the timeout of the backend serving the method for calls
+// without a deadline of their own.
+//
+// Bootstrap makes the method public while ADMIN_BOOTSTRAP is set, so that the
+// first admin can be created on a new deployment.
+type MethodPolicy struct {
+	Deny               bool             `yaml:"deny"`
+	Public             bool             `yaml:"public"`
+	Bootstrap          bool             `yaml:"bootstrap"`
+	Roles              []string         `yaml:"roles"`
+	Permissions        []string         `yaml:"permissions"`
+	Owner              []string         `yaml:"owner"`
+	OwnerOverrideRoles []string         `yaml:"ownerOverrideRoles"`
+	Scopes             []string         `yaml:"scopes"`
+	ApiTokenOnly       bool             `yaml:"apiTokenOnly"`
+	AllowTfaPending    bool             `yaml:"allowTfaPending"`
+	RateLimit          *RateLimitPolicy `yaml:"rateLimit"`
+	Lockout            string           `yaml:"lockout"`
+	Content            *ContentPolicy   `yaml:"content"`
+	MaxBodySize        ByteSize         `yaml:"maxBodySize"`
+	Audit              *AuditPolicy     `yaml:"audit"`
+	Timeout            time.Duration    `yaml:"timeout"`
+}
+
+// AuditPolicy names the action a method is recorded as in the audit log, e.g.
+// "user.delete", and lists dotted paths of request fields identifying the user
+// or object acted on.
+type AuditPolicy struct {
+	Action string   `yaml:"action"`
+	Target []string `yaml:"target"`
+}
+
+// ContentPolicy selects how markup in the user generated content of a method is
+// handled: "reject" (the default) rejects requests that look like an attack,
+// "strip" removes every tag and attribute not on the allowlist and "escape"
+// escapes all markup. Fields names the content fields by proto or JSON name;
+// every string field is content when empty.
+type ContentPolicy struct {
+	Mode   string   `yaml:"mode"`
+	Fields []string `yaml:"fields"`
+}
+
+// RateLimitPolicy holds the budgets of a method per client IP, per
+// authenticated user and per value of request fields such as the username.
+type RateLimitPolicy struct {
+	Ip     *RateLimit            `yaml:"ip"`
+	User   *RateLimit            `yaml:"user"`
+	Fields map[string]*RateLimit `yaml:"fields"`
+}
+
+// RateLimit allows Requests calls every Per. It is written as "5/1m".
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (r *RateLimit) UnmarshalYAML(value *yaml.Node) error {
+	requests, per, found := strings.Cut(value.Value, "/")
+	if !found {
+		return fmt.Errorf("line %d: rate limit %q is not of the form <requests>/<duration>", value.Line, value.Value)
+	}
+	parsedRequests, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || parsedRequests <= 0 {
+		return fmt.Errorf("line %d: rate limit %q needs a positive number of requests", value.Line, value.Value)
+	}
+	parsedPer, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || parsedPer <= 0 {
+		return fmt.Errorf("line %d: rate limit %q needs a positive duration", value.Line, value.Value)
+	}
+	r.Requests = parsedRequests
+	r.Per = parsedPer
+	return nil
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	err = yaml.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Method returns the rule for fullMethod. Methods without a rule must be denied.
+func (p *Policy) Method(fullMethod string) (*MethodPolicy, bool) {
+	method, ok := p.Methods[fullMethod]
+	return method, ok && method != nil
+}
+
+func (p *Policy) RoleHasPermission(role string, permission string) bool {
+	for _, granted := range p.Roles[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnerOverrideRolesFor returns the roles that may bypass the ownership check of
+// method, falling back to the policy wide default.
+func (p *Policy) OwnerOverrideRolesFor(method *MethodPolicy) []string {
+	if method.OwnerOverrideRoles != nil {
+		return method.OwnerOverrideRoles
+	}
+	return p.OwnerOverrideRoles
+}
+
+// IsApiTokenScope reports whether scope may be granted to an API token.
+func (p *Policy) IsApiTokenScope(scope string) bool {
+	for _, known := range p.ApiTokenScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ApiTokenPlanFor returns the plan limiting the API token of userId. The plan is
+// nil when API tokens are not limited.
+func (p *Policy) ApiTokenPlanFor(userId string) (string, *ApiTokenPlan) {
+	for name, plan := range p.ApiTokenPlans {
+		for _, user := range plan.Users {
+			if user == userId {
+				return name, plan
+			}
+		}
+	}
+	return p.DefaultApiTokenPlan, p.ApiTokenPlans[p.DefaultApiTokenPlan]
+}
+
+// Validate checks that every registered method has a rule and that rules only
+// reference known roles, permissions and scopes.
+func (p *Policy) Validate(registeredMethods []string) error {
+	var problems []string
+
+	for _, role := range p.OwnerOverrideRoles {
+		if _, ok := p.Roles[role]; !ok {
+			problems = append(problems, "owner override references unknown role "+role)
+		}
+	}
+	for _, scope := range p.DefaultApiTokenScopes {
+		if !p.IsApiTokenScope(scope) {
+			problems = append(problems, "default API token scope "+scope+" is not an API token scope")
+		}
+	}
+	if _, ok := p.ApiTokenPlans[p.DefaultApiTokenPlan]; p.DefaultApiTokenPlan != "" && !ok {
+		problems = append(problems, "default API token plan "+p.DefaultApiTokenPlan+" does not exist")
+	}
+	planOf := map[string]string{}
+	for name, plan := range p.ApiTokenPlans {
+		for _, user := range plan.Users {
+			if other, ok := planOf[user]; ok {
+				problems = append(problems, "user "+user+" is in API token plans "+other+" and "+name)
+			}
+			planOf[user] = name
+		}
+	}
+	for _, method := range registeredMethods {
+		if _, ok := p.Method(method); !ok {
+			problems = append(problems, "no rule for "+method)
+		}
+	}
+
+	permissions := map[string]bool{}
+	for _, granted := range p.Roles {
+		for _, permission := range granted {
+			permissions[permission] = true
+		}
+	}
+
+	for name, method := range p.Methods {
+		if method == nil {
+			continue
+		}
+		if method.Deny && method.Public {
+			problems = append(problems, name+" is both denied and public")
+		}
+		if method.Public && (len(method.Roles) > 0 || len(method.Permissions) > 0 || len(method.Owner) > 0) {
+			problems = append(problems, name+" is public but lists roles, permissions or owner fields")
+		}
+		for _, role := range append(method.Roles, method.OwnerOverrideRoles...) {
+			if _, ok := p.Roles[role]; !ok {
+				problems = append(problems, name+" references unknown role "+role)
+			}
+		}
+		for _, permission := range method.Permissions {
+			if !permissions[permission] {
+				problems = append(problems, name+" requires permission "+permission+" which no role grants")
+			}
+		}
+		for _, scope := range method.Scopes {
+			if !p.IsApiTokenScope(scope) {
+				problems = append(problems, name+" requires scope "+scope+" which is not an API token scope")
+			}
+		}
+		if method.ApiTokenOnly && (method.Public || len(method.Scopes) == 0) {
+			problems = append(problems, name+" is API token only but public or without scopes")
+		}
+		if method.Content != nil {
+			switch method.Content.Mode {
+			case "", "reject", "strip", "escape":
+			default:
+				problems = append(problems, name+" has unknown content mode "+method.Content.Mode)
+			}
+		}
+		if method.Timeout < 0 {
+			problems = append(problems, name+" has a negative timeout")
+		}
+		if method.Audit != nil && method.Audit.Action == "" {
+			problems = append(problems, name+" is audited without an action")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid policy:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// OpenBootstrapMethods makes the bootstrap methods public, dropping their
+// roles, permissions and owner fields, and returns their names.
+func (p *Policy) OpenBootstrapMethods() []string {
+	var opened []string
+	for name, method := range p.Methods {
+		if method == nil || !method.Bootstrap || method.Deny {
+			continue
+		}
+		method.Public = true
+		method.Roles = nil
+		method.Permissions = nil
+		method.Owner = nil
+		opened = append(opened, name)
+	}
+	sort.Strings(opened)
+	return opened
+}
+
+// LargestBodySize returns the largest request body size allowed for any
+// method, which is fallback unless a method allows more.
+func (p *Policy) LargestBodySize(fallback ByteSize) ByteSize {
+	largest := fallback
+	for _, method := range p.Methods {
+		if method != nil && method.MaxBodySize > largest {
+			largest = method.MaxBodySize
+		}
+	}
+	return largest
+}
+
+// UnknownMethods returns the methods that have a rule but are not registered,
+// which usually means a typo in the policy file.
+func (p *Policy) UnknownMethods(registeredMethods []string) []string {
+	registered := make(map[string]bool, len(registeredMethods))
+	for _, method := range registeredMethods {
+		registered[method] = true
+	}
+	var unknown []string
+	for name := range p.Methods {
+		if !registered[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
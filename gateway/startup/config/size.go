@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes, written as a number of bytes or with one of the
+// units KB, MB and GB (powers of 1024), e.g. "512KB" or "10MB".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func ParseByteSize(value string) (ByteSize, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	unit := ByteSize(1)
+	for _, candidate := range byteSizeUnits {
+		if strings.HasSuffix(number, candidate.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, candidate.suffix))
+			unit = candidate.size
+			break
+		}
+	}
+	parsed, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("size %q is not a positive number of bytes, KB, MB or GB", value)
+	}
+	return ByteSize(parsed) * unit, nil
+}
+
+func (s *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*s = size
+	return nil
+}
+
+func (s ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if s%unit.size == 0 {
+			return strconv.FormatInt(int64(s/unit.size), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Formats known to FieldRule.Format.
+const (
+	FormatObjectId = "objectId"
+	FormatEmail    = "email"
+)
+
+// ValidationRules are the constraints on the fields of proxied requests.
+// Messages maps full proto message names such as user.UserIdRequest to the
+// rules of their fields, named by JSON or proto name. Rules apply wherever the
+// message occurs in a request, including nested in other messages.
+type ValidationRules struct {
+	Defaults FieldDefaults                    `yaml:"defaults"`
+	Messages map[string]map[string]*FieldRule `yaml:"messages"`
+}
+
+// FieldDefaults apply to every field of every request. MaxLength limits
+// string fields without a maxLength of their own; DefinedEnums rejects enum
+// values the proto does not define.
+type FieldDefaults struct {
+	MaxLength    int  `yaml:"maxLength"`
+	DefinedEnums bool `yaml:"definedEnums"`
+}
+
+// FieldRule constrains a single field. Required rejects the zero value, or an
+// empty list. Lengths count characters of strings and elements of lists, and
+// Min and Max bound numbers and enum values. Pattern must match the whole
+// string. Format is one of objectId (a hex MongoDB ObjectID) and email.
+type FieldRule struct {
+	Required  bool     `yaml:"required"`
+	MinLength int      `yaml:"minLength"`
+	MaxLength int      `yaml:"maxLength"`
+	Pattern   string   `yaml:"pattern"`
+	Format    string   `yaml:"format"`
+	Min       *float64 `yaml:"min"`
+	Max       *float64 `yaml:"max"`
+}
+
+func LoadValidationRules(path string) (*ValidationRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &ValidationRules{}
+	err = yaml.Unmarshal(data, rules)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rules, rules.validate()
+}
+
+// validate checks the rules that don't depend on the proto definitions.
+func (r *ValidationRules) validate() error {
+	var problems []string
+	for message, fields := range r.Messages {
+		for field, rule := range fields {
+			if rule == nil {
+				continue
+			}
+			name := message + "." + field
+			if rule.Pattern != "" {
+				if _, err := regexp.Compile(rule.Pattern); err != nil {
+					problems = append(problems, name+" has an invalid pattern: "+err.Error())
+				}
+			}
+			switch rule.Format {
+			case "", FormatObjectId, FormatEmail:
+			default:
+				problems = append(problems, name+" has unknown format "+rule.Format)
+			}
+			if rule.MaxLength > 0 && rule.MinLength > rule.MaxLength {
+				problems = append(problems, name+" has a minLength above its maxLength")
+			}
+			if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+				problems = append(problems, name+" has a min above its max")
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid validation rules:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
@@ -3,7 +3,15 @@ package startup
 import (
 	"context"
 	"fmt"
+	"gateway/domain"
 	"gateway/infrastructure/api"
+	"gateway/infrastructure/audit"
+	"gateway/infrastructure/authcache"
+	"gateway/infrastructure/clients"
+	"gateway/infrastructure/health"
+	"gateway/infrastructure/metrics"
+	"gateway/infrastructure/persistence"
+	"gateway/infrastructure/verifier"
 	"gateway/startup/config"
 	connectionService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/connection"
 	jobService "github.com/XWS-BSEP-TIM1-2022/dislinkt/util/proto/job"
@@ -15,17 +23,38 @@ import (
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	otgo "github.com/opentracing/opentracing-go"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Server struct {
 	userService.UnimplementedUserServiceServer
-	tracer otgo.Tracer
-	closer io.Closer
-	Config *config.Config
+	tracer      otgo.Tracer
+	closer      io.Closer
+	stop        chan struct{}
+	workers     sync.WaitGroup
+	ready       int32
+	authCache   *authcache.Cache
+	clients     *clients.Factory
+	policy      *config.Policy
+	scopeStore  domain.ApiTokenScopeStore
+	revocations domain.RevocationStore
+	usageStore  domain.ApiTokenUsageStore
+	validation  *config.ValidationRules
+	auditLog    *audit.Log
+	Config      *config.Config
 }
 
 const name = "gateway"
@@ -34,9 +63,12 @@ func NewServer(config *config.Config) (*Server, error) {
 	tracer, closer := tracer.Init(name)
 	otgo.SetGlobalTracer(tracer)
 	server := &Server{
-		tracer: tracer,
-		closer: closer,
-		Config: config,
+		tracer:    tracer,
+		closer:    closer,
+		stop:      make(chan struct{}),
+		authCache: authcache.NewCache(config.AuthCacheSize, config.AuthCacheTtl),
+		clients:   clients.NewFactory(config),
+		Config:    config,
 	}
 
 	return server, nil
@@ -53,22 +85,90 @@ func (server *Server) CloseTracer() error {
 	return server.closer.Close()
 }
 
-func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatewayS *api.PostGatewayStruct, connectionGatewayS *api.ConnectionGatewayStruct, jobGatewayS *api.JobGatewayStruct, messageGatewayS *api.MessageGatewayStruct) {
-	// Create a listener on TCP port
-	defer server.CloseTracer()
+// Ready reports whether the gateway is serving and not shutting down.
+func (server *Server) Ready() bool {
+	return atomic.LoadInt32(&server.ready) == 1
+}
 
+func (server *Server) setReady(ready bool) {
+	value := int32(0)
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&server.ready, value)
+}
+
+// background runs work, which must return once server.stop is closed.
+// Shutting down waits for it to return.
+func (server *Server) background(work func()) {
+	server.workers.Add(1)
+	go func() {
+		defer server.workers.Done()
+		work()
+	}()
+}
+
+// StartServer serves until the process receives SIGINT or SIGTERM, or until a
+// server fails, and shuts down gracefully in both cases. It returns the error
+// of the failed server.
+func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatewayS *api.PostGatewayStruct, connectionGatewayS *api.ConnectionGatewayStruct, jobGatewayS *api.JobGatewayStruct, messageGatewayS *api.MessageGatewayStruct, authGatewayS *api.AuthGatewayStruct, usageGatewayS *api.UsageGatewayStruct) error {
+	// Create a listener on TCP port
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", server.Config.GrpcPort))
 	if err != nil {
 		log.Fatalln("Failed to listen:", err)
 	}
 
-	// Create a gRPC server object
-	s := grpc.NewServer()
+	policy := server.policy
+	jwtVerifier := server.initJwtVerifier()
 
+	// Create a gRPC server object
+	principalResolver := api.NewPrincipalResolver(server.Config, server.clients, policy, jwtVerifier, server.authCache, server.scopeStore, server.revocations)
+	authInterceptor := api.NewAuthInterceptor(policy, principalResolver)
+	rateLimitInterceptor := api.NewRateLimitInterceptor(server.Config, policy)
+	quotaInterceptor := api.NewQuotaInterceptor(policy, server.usageStore)
+	sanitizeInterceptor, err := api.NewSanitizeInterceptor(policy)
 	if err != nil {
-		log.Fatal(err.Error())
-		return
+		log.Fatalln("Invalid sanitize policy:", err)
 	}
+	validationInterceptor := api.NewValidationInterceptor(server.validation)
+	deadlineInterceptor, err := api.NewDeadlineInterceptor(server.Config, policy)
+	if err != nil {
+		log.Fatalln("Invalid timeouts:", err)
+	}
+	auditInterceptor := api.NewAuditInterceptor(policy, server.auditLog)
+	bodyLimiter := api.NewBodyLimiter(server.Config, policy)
+	// The server accepts what the largest allowed request body encodes to, the
+	// body limiter enforces the limit of each method.
+	maxRecvMsgSize := policy.LargestBodySize(server.Config.GrpcMaxRecvMsgSize)
+	s := grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(maxRecvMsgSize)),
+		grpc.MaxSendMsgSize(int(server.Config.GrpcMaxSendMsgSize)),
+		grpc.ChainUnaryInterceptor(
+			metrics.UnaryServerInterceptor(),
+			api.UnaryRecoveryInterceptor(),
+			grpc_opentracing.UnaryServerInterceptor(
+				grpc_opentracing.WithTracer(otgo.GlobalTracer()),
+				grpc_opentracing.WithFilterFunc(traced),
+			),
+			api.UnaryAccessLog(),
+			deadlineInterceptor.Unary(),
+			auditInterceptor.Unary(),
+			api.UnaryErrorInterceptor(),
+			authInterceptor.Unary(),
+			bodyLimiter.Unary(),
+			rateLimitInterceptor.Unary(),
+			sanitizeInterceptor.Unary(),
+			validationInterceptor.Unary(),
+			quotaInterceptor.Unary(),
+		),
+		grpc.ChainStreamInterceptor(
+			api.StreamRecoveryInterceptor(),
+			grpc_opentracing.StreamServerInterceptor(
+				grpc_opentracing.WithTracer(otgo.GlobalTracer()),
+				grpc_opentracing.WithFilterFunc(traced),
+			),
+		),
+	)
 
 	// Attach the Greeter service to the server
 	userService.RegisterUserServiceServer(s, userGatewayS)
@@ -76,10 +176,41 @@ func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatew
 	connectionService.RegisterConnectionServiceServer(s, connectionGatewayS)
 	jobService.RegisterJobServiceServer(s, jobGatewayS)
 	messageService.RegisterMessageServiceServer(s, messageGatewayS)
+	api.RegisterAuthServiceServer(s, authGatewayS)
+	api.RegisterUsageServiceServer(s, usageGatewayS)
+	checker := health.NewChecker(server.clients.Backends(), server.Config.OptionalBackends, server.Ready)
+	healthpb.RegisterHealthServer(s, checker.Server())
+	for _, backend := range checker.UnknownBackends() {
+		log.Println("Optional backend", backend, "is unknown")
+	}
+
+	methods := registeredMethods(s)
+	err = policy.Validate(methods)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = api.ValidateRequestFields(policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, method := range policy.UnknownMethods(methods) {
+		log.Println("Policy has a rule for unregistered method", method)
+	}
+	unknownMessages, err := api.ValidateRuleFields(server.validation)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, message := range unknownMessages {
+		log.Println("Validation rules for unknown message", message)
+	}
 	// Serve gRPC server
 	log.Println(fmt.Sprintf("Serving gRPC on localhost:%s", server.Config.GrpcPort))
+	failed := make(chan error, 3)
 	go func() {
-		log.Fatalln(s.Serve(lis))
+		err := s.Serve(lis)
+		if err != nil {
+			failed <- fmt.Errorf("gRPC server: %w", err)
+		}
 	}()
 
 	// Create a client connection to the gRPC server we just started
@@ -89,6 +220,10 @@ func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatew
 		fmt.Sprintf("0.0.0.0:%s", server.Config.GrpcPort),
 		grpc.WithBlock(),
 		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(int(server.Config.GrpcMaxSendMsgSize)),
+			grpc.MaxCallSendMsgSize(int(maxRecvMsgSize)),
+		),
 		grpc.WithUnaryInterceptor(
 			grpc_opentracing.UnaryClientInterceptor(
 				grpc_opentracing.WithTracer(otgo.GlobalTracer()),
@@ -104,7 +239,14 @@ func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatew
 		log.Fatalln("Failed to dial server:", err)
 	}
 
-	gwmux := runtime.NewServeMux()
+	gwmux := runtime.NewServeMux(
+		runtime.WithErrorHandler(api.ErrorHandler),
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+		runtime.WithMetadata(bodyLimiter.Annotate),
+		runtime.WithMetadata(metrics.AnnotateRoute),
+		runtime.WithMetadata(api.AnnotateCall),
+	)
 	// Register Greeter
 	err = userService.RegisterUserServiceHandler(context.Background(), gwmux, conn)
 	if err != nil {
@@ -127,22 +269,260 @@ func (server *Server) StartServer(userGatewayS *api.UserGatewayStruct, postGatew
 	if err != nil {
 		log.Fatalln("Failed to register Connection gateway:", err)
 	}
+	err = api.RegisterAuthServiceHandler(gwmux, conn)
+	if err != nil {
+		log.Fatalln("Failed to register Auth gateway:", err)
+	}
+	err = api.RegisterUsageServiceHandler(gwmux, conn)
+	if err != nil {
+		log.Fatalln("Failed to register Usage gateway:", err)
+	}
+	err = gwmux.HandlePath(http.MethodGet, "/healthz", checker.Liveness)
+	if err != nil {
+		log.Fatalln("Failed to register health check:", err)
+	}
+	err = gwmux.HandlePath(http.MethodGet, "/readyz", checker.Readiness)
+	if err != nil {
+		log.Fatalln("Failed to register readiness check:", err)
+	}
 
 	gwServer := &http.Server{
-		Addr:    fmt.Sprintf(":%s", server.Config.HttpPort),
-		Handler: tracer.TracingWrapper(gwmux),
+		Addr:              fmt.Sprintf(":%s", server.Config.HttpPort),
+		Handler:           tracer.TracingWrapper(api.HttpAccessLog(metrics.HttpHandler(bodyLimiter.Handler(gwmux)))),
+		MaxHeaderBytes:    int(server.Config.HttpMaxHeaderSize),
+		ReadHeaderTimeout: server.Config.HttpReadHeaderTimeout,
+		ReadTimeout:       server.Config.HttpReadTimeout,
+		IdleTimeout:       server.Config.HttpIdleTimeout,
 	}
 
 	log.Println(fmt.Sprintf("Serving gRPC-Gateway on https://localhost:%s", server.Config.HttpPort))
+	go func() {
+		err := gwServer.ListenAndServeTLS(server.Config.CertificatePath, server.Config.CertificateKeyPath)
+		if err != http.ErrServerClosed {
+			failed <- fmt.Errorf("gRPC-Gateway server: %w", err)
+		}
+	}()
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	metricsServer := &http.Server{
+		Addr:              fmt.Sprintf(":%s", server.Config.MetricsPort),
+		Handler:           metricsMux,
+		ReadHeaderTimeout: server.Config.HttpReadHeaderTimeout,
+	}
+	log.Println(fmt.Sprintf("Serving metrics on http://localhost:%s/metrics", server.Config.MetricsPort))
+	go func() {
+		err := metricsServer.ListenAndServe()
+		if err != http.ErrServerClosed {
+			failed <- fmt.Errorf("metrics server: %w", err)
+		}
+	}()
+	server.setReady(true)
+	server.background(func() {
+		checker.Watch(server.Config.HealthCheckInterval, server.stop, api.Log)
+	})
+	server.background(func() {
+		server.auditLog.AnchorEvery(server.Config.AuditAnchorInterval, server.stop, api.Log)
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var failure error
+	select {
+	case received := <-signals:
+		log.Println("Received", received, "shutting down")
+	case failure = <-failed:
+		log.Println("Shutting down after failure of", failure)
+	}
+	server.shutdown(gwServer, s, conn, checker)
+	metricsServer.Close()
+	return failure
+}
 
-	log.Fatalln(gwServer.ListenAndServeTLS(server.Config.CertificatePath, server.Config.CertificateKeyPath))
+// shutdown marks the gateway not ready, lets the HTTP and gRPC servers finish
+// the requests in flight for up to the shutdown timeout, then stops the
+// background workers and closes the audit log, the backend connections and the
+// tracer.
+func (server *Server) shutdown(gwServer *http.Server, s *grpc.Server, conn *grpc.ClientConn, checker *health.Checker) {
+	server.setReady(false)
+	checker.Shutdown()
+	// Give load balancers time to notice before connections are refused.
+	time.Sleep(server.Config.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), server.Config.ShutdownTimeout)
+	defer cancel()
+	// HTTP requests are served through the gRPC server, so it is drained second.
+	err := gwServer.Shutdown(ctx)
+	if err != nil {
+		log.Println("Closing HTTP connections still in use:", err)
+		gwServer.Close()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("Cancelling gRPC calls still in flight")
+		s.Stop()
+	}
+
+	close(server.stop)
+	server.workers.Wait()
+	conn.Close()
+	seq, hash := server.auditLog.Head()
+	api.Log.Info("Audit log closed at entry " + strconv.FormatUint(seq, 10) + " with hash " + hash)
+	err = server.auditLog.Close()
+	if err != nil {
+		log.Println("Failed to close the audit log:", err)
+	}
+	err = server.clients.Close()
+	if err != nil {
+		log.Println("Failed to close backend connections:", err)
+	}
+	err = server.CloseTracer()
+	if err != nil {
+		log.Println("Failed to flush traces:", err)
+	}
+	log.Println("Gateway stopped")
+}
+
+// initJwtVerifier loads the key set used to verify JWTs locally and keeps it up
+// to date. It returns nil when no key set is configured.
+func (server *Server) initJwtVerifier() *verifier.Verifier {
+	if server.Config.JwtKeySetPath == "" {
+		log.Println("No JWT key set configured, tokens are verified by the user service")
+		return nil
+	}
+	jwtVerifier, err := verifier.NewVerifier(server.Config.JwtKeySetPath, verifier.ClaimNames{
+		UserId:     server.Config.JwtUserIdClaim,
+		Role:       server.Config.JwtRoleClaim,
+		TfaPending: server.Config.JwtTfaPendingClaim,
+	})
+	if err != nil {
+		log.Fatalln("Failed to load JWT key set:", err)
+	}
+	server.background(func() {
+		jwtVerifier.Watch(server.Config.JwtKeySetReload, server.stop, api.Log)
+	})
+	return jwtVerifier
+}
+
+// traced leaves the health checks of orchestrators out of the traces. Calls
+// continue the trace found in their metadata, which is where the HTTP gateway
+// and the callers of the gRPC API put it.
+func traced(ctx context.Context, fullMethod string) bool {
+	return !strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+// forwardedHeaders are passed to the gRPC handlers as metadata in addition to
+// the headers grpc-gateway forwards by default.
+var forwardedHeaders = map[string]bool{
+	api.ApiKeyHeader:         true,
+	api.ApiTokenScopesHeader: true,
+	api.RequestIdHeader:      true,
+}
+
+func incomingHeaderMatcher(key string) (string, bool) {
+	if forwardedHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+		return key, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
 }
 
-func (server *Server) Start() {
-	userGateway, postGateway, connectionGateway, jobGateway, messageGateway := server.initHandlers()
-	server.StartServer(userGateway, postGateway, connectionGateway, jobGateway, messageGateway)
+// outgoingHeaderMatcher passes the rate limit headers to HTTP clients under
+// their own names and every other header as Grpc-Metadata-<name>.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	for _, header := range api.RateLimitHeaders {
+		if textproto.CanonicalMIMEHeaderKey(key) == header {
+			return header, true
+		}
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func registeredMethods(s *grpc.Server) []string {
+	var methods []string
+	for service, info := range s.GetServiceInfo() {
+		for _, method := range info.Methods {
+			methods = append(methods, fmt.Sprintf("/%s/%s", service, method.Name))
+		}
+	}
+	return methods
+}
+
+// Start serves until the gateway is shut down, see StartServer.
+func (server *Server) Start() error {
+	policy, err := config.LoadPolicy(server.Config.PolicyPath)
+	if err != nil {
+		log.Fatalln("Failed to load policy:", err)
+	}
+	if server.Config.AdminBootstrap {
+		for _, method := range policy.OpenBootstrapMethods() {
+			log.Println("ADMIN_BOOTSTRAP is set, serving", method, "to anonymous callers")
+		}
+	}
+	server.policy = policy
+	server.validation, err = config.LoadValidationRules(server.Config.ValidationPath)
+	if err != nil {
+		log.Fatalln("Failed to load validation rules:", err)
+	}
+	server.scopeStore, err = persistence.NewApiTokenScopeFileStore(server.Config.ApiTokenScopesPath)
+	if err != nil {
+		log.Fatalln("Failed to load API token scopes:", err)
+	}
+
+	if server.Config.AuditLogKey == "" {
+		log.Println("AUDIT_LOG_KEY is not set, whoever can write the audit log can rewrite its chain")
+	}
+	server.auditLog, err = audit.Open(server.Config.AuditLogPath, []byte(server.Config.AuditLogKey))
+	if err != nil {
+		log.Fatalln("Failed to open the audit log:", err)
+	}
+	server.revocations = server.initRevocationStore()
+	server.usageStore = server.initUsageStore()
+
+	userGateway, postGateway, connectionGateway, jobGateway, messageGateway, authGateway, usageGateway := server.initHandlers()
+	return server.StartServer(userGateway, postGateway, connectionGateway, jobGateway, messageGateway, authGateway, usageGateway)
+}
+
+func (server *Server) initRevocationStore() domain.RevocationStore {
+	switch server.Config.RevocationStore {
+	case "memory":
+		log.Println("Logged out tokens are kept in memory and forgotten on restart")
+		return persistence.NewRevocationMemoryStore()
+	case "file":
+		revocations, err := persistence.NewRevocationFileStore(server.Config.RevocationStorePath)
+		if err != nil {
+			log.Fatalln("Failed to load logged out tokens:", err)
+		}
+		return revocations
+	}
+	log.Fatalln("Unknown revocation store:", server.Config.RevocationStore)
+	return nil
+}
+
+func (server *Server) initUsageStore() domain.ApiTokenUsageStore {
+	switch server.Config.UsageStore {
+	case "memory":
+		log.Println("API token usage is kept in memory and forgotten on restart")
+		return persistence.NewApiTokenUsageMemoryStore()
+	case "file":
+		usageStore, err := persistence.NewApiTokenUsageFileStore(server.Config.UsageStorePath)
+		if err != nil {
+			log.Fatalln("Failed to load API token usage:", err)
+		}
+		server.background(func() {
+			usageStore.FlushEvery(server.Config.UsageFlushInterval, server.stop, api.Log)
+		})
+		return usageStore
+	}
+	log.Fatalln("Unknown usage store:", server.Config.UsageStore)
+	return nil
 }
 
-func (server *Server) initHandlers() (*api.UserGatewayStruct, *api.PostGatewayStruct, *api.ConnectionGatewayStruct, *api.JobGatewayStruct, *api.MessageGatewayStruct) {
-	return api.NewUserGateway(server.Config), api.NewPostGateway(server.Config), api.NewConnectionGateway(server.Config), api.NewJobGateway(server.Config), api.NewMessageGateway(server.Config)
+func (server *Server) initHandlers() (*api.UserGatewayStruct, *api.PostGatewayStruct, *api.ConnectionGatewayStruct, *api.JobGatewayStruct, *api.MessageGatewayStruct, *api.AuthGatewayStruct, *api.UsageGatewayStruct) {
+	return api.NewUserGateway(server.Config, server.clients, server.policy, server.authCache, server.scopeStore), api.NewPostGateway(server.Config, server.clients), api.NewConnectionGateway(server.Config, server.clients), api.NewJobGateway(server.Config, server.clients), api.NewMessageGateway(server.Config, server.clients), api.NewAuthGateway(server.Config, server.revocations, server.authCache), api.NewUsageGateway(server.policy, server.usageStore)
 }
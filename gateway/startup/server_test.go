@@ -0,0 +1,190 @@
+package startup
+
+import (
+	"context"
+	"gateway/infrastructure/api"
+	"gateway/infrastructure/audit"
+	"gateway/infrastructure/authcache"
+	"gateway/infrastructure/clients"
+	"gateway/infrastructure/health"
+	"gateway/startup/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// testServer returns a server that can be shut down, with its audit log in a
+// temporary directory and no tracer.
+func testServer(t *testing.T, timeout time.Duration) *Server {
+	c := config.NewConfig()
+	c.ShutdownDelay = 0
+	c.ShutdownTimeout = timeout
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"), []byte("test key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := api.Log.Out
+	api.Log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		api.Log.SetOutput(previous)
+	})
+	return &Server{
+		closer:    nopCloser{},
+		stop:      make(chan struct{}),
+		authCache: authcache.NewCache(10, time.Minute),
+		clients:   clients.NewFactory(c),
+		auditLog:  auditLog,
+		Config:    c,
+	}
+}
+
+// slowService answers /test.Slow/Call once release is closed, after telling
+// started that a call arrived.
+func slowService(started chan<- struct{}, release <-chan struct{}) *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "test.Slow",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Call",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := dec(new(emptypb.Empty)); err != nil {
+					return nil, err
+				}
+				started <- struct{}{}
+				select {
+				case <-release:
+					return &emptypb.Empty{}, nil
+				case <-ctx.Done():
+					return nil, status.FromContextError(ctx.Err()).Err()
+				}
+			},
+		}},
+	}
+}
+
+// serve starts the HTTP and gRPC servers of a gateway whose requests and calls
+// wait for release, and returns them with a connection to the gRPC server.
+func serve(t *testing.T, started chan<- struct{}, release <-chan struct{}) (*http.Server, *grpc.Server, *grpc.ClientConn) {
+	s := grpc.NewServer()
+	s.RegisterService(slowService(started, release), nil)
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(grpcListener)
+
+	gwServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})}
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gwServer.Serve(httpListener)
+	gwServer.Addr = httpListener.Addr().String()
+
+	conn, err := grpc.Dial(grpcListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gwServer, s, conn
+}
+
+// callBoth starts an HTTP request and a gRPC call and returns the channels
+// their outcomes are sent to.
+func callBoth(gwServer *http.Server, conn *grpc.ClientConn) (<-chan int, <-chan error) {
+	httpDone := make(chan int, 1)
+	grpcDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + gwServer.Addr)
+		if err != nil {
+			httpDone <- 0
+			return
+		}
+		resp.Body.Close()
+		httpDone <- resp.StatusCode
+	}()
+	go func() {
+		grpcDone <- conn.Invoke(context.Background(), "/test.Slow/Call", &emptypb.Empty{}, &emptypb.Empty{})
+	}()
+	return httpDone, grpcDone
+}
+
+func TestShutdownDrainsCallsInFlight(t *testing.T) {
+	server := testServer(t, 5*time.Second)
+	server.setReady(true)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	gwServer, s, conn := serve(t, started, release)
+	checker := health.NewChecker(nil, nil, server.Ready)
+
+	httpDone, grpcDone := callBoth(gwServer, conn)
+	<-started
+	<-started
+	time.AfterFunc(50*time.Millisecond, func() {
+		if server.Ready() {
+			t.Error("gateway still ready while shutting down")
+		}
+		close(release)
+	})
+	server.shutdown(gwServer, s, conn, checker)
+
+	select {
+	case code := <-httpDone:
+		if code != http.StatusOK {
+			t.Errorf("HTTP request in flight got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Error("HTTP request in flight did not finish")
+	}
+	select {
+	case err := <-grpcDone:
+		if err != nil {
+			t.Errorf("gRPC call in flight got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("gRPC call in flight did not finish")
+	}
+}
+
+func TestShutdownCancelsCallsAfterTheTimeout(t *testing.T) {
+	server := testServer(t, 100*time.Millisecond)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	gwServer, s, conn := serve(t, started, release)
+	checker := health.NewChecker(nil, nil, server.Ready)
+
+	_, grpcDone := callBoth(gwServer, conn)
+	<-started
+	<-started
+	start := time.Now()
+	server.shutdown(gwServer, s, conn, checker)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %v with a timeout of 100ms", elapsed)
+	}
+	select {
+	case err := <-grpcDone:
+		if status.Code(err) == codes.OK {
+			t.Error("call still in flight after the timeout succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Error("call still in flight after the timeout was not cancelled")
+	}
+}